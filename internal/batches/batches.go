@@ -0,0 +1,548 @@
+package batches
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"main/internal/state"
+	"main/internal/structs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// stateSection is the state store section the batch history is persisted in.
+const stateSection = "batches"
+
+type State = string
+
+const (
+	Queued  State = "queued"
+	Running State = "running"
+	// Pending batches wait for an operator decision.
+	Pending State = "pending"
+	// Deferred batches are held back until SMC can be reached.
+	Deferred State = "deferred"
+	Success  State = structs.Success
+	Failed   State = structs.Failed
+	// Partial batches were applied to some of their lists but failed for others.
+	Partial State = "partial"
+	// Planned batches were dry runs, their lists record the changes which would have been made.
+	Planned State = "planned"
+)
+
+// Item results which determine the changes a batch applied.
+const (
+	ItemAdded   = "added"
+	ItemPresent = "already_present"
+	ItemRemoved = "removed"
+)
+
+// ErrNotRevertible is returned when a rollback is requested for a batch which cannot be rolled back.
+var ErrNotRevertible = errors.New("the batch has not been applied, has already been rolled back or is past the rollback window")
+
+type Outcome int
+
+const (
+	// New batches have not been seen before.
+	New Outcome = iota
+	// Duplicate batches were already submitted with the same content.
+	Duplicate
+	// Conflict batches were already submitted with different content.
+	Conflict
+	// InFlight batches were already submitted with different content, which is still being processed.
+	InFlight
+)
+
+// Record is the processing history of a single batch.
+type Record struct {
+	ID         int                `json:"id"`
+	Hash       string             `json:"hash"`
+	State      State              `json:"state"`
+	Flagged    bool               `json:"flagged,omitempty"`
+	DryRun     bool               `json:"dry_run,omitempty"`
+	UpdateType structs.UpdateType `json:"update_type"`
+	SafeList   bool               `json:"safe_list"`
+	ItemCount  int                `json:"item_count"`
+	Received   time.Time          `json:"received"`
+	Started    *time.Time         `json:"started,omitempty"`
+	Finished   *time.Time         `json:"finished,omitempty"`
+	Lists      []ListResult       `json:"lists,omitempty"`
+	// Applied are the changes made to each list, which a rollback reverts.
+	Applied  []ListChange `json:"applied,omitempty"`
+	Rollback *Rollback    `json:"rollback,omitempty"`
+	// Interrupted batches failed as the module stopped before they finished, they are processed
+	// again when they are resubmitted.
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// ListChange is the set of items a batch changed in one list. Present items were already in the
+// list, the batch relies on them as well.
+type ListChange struct {
+	Key     string   `json:"key"`
+	List    string   `json:"list"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Present []string `json:"present,omitempty"`
+	// Released maps sources to the items whose reference the batch released, which a rollback
+	// records again.
+	Released map[string][]string `json:"released,omitempty"`
+}
+
+// Rollback is the history of reverting a batch.
+type Rollback struct {
+	State     State        `json:"state"`
+	Requested time.Time    `json:"requested"`
+	Finished  *time.Time   `json:"finished,omitempty"`
+	Lists     []ListResult `json:"lists,omitempty"`
+}
+
+// ListResult is the outcome of a batch for one of the lists it targeted.
+type ListResult struct {
+	List string `json:"list"`
+	// Key identifies the list the result applies to, it is empty for the snort configuration.
+	Key string `json:"key,omitempty"`
+	// CreateList is set when a dry run found that the list does not exist yet.
+	CreateList bool   `json:"create_list,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Conflicts are the items which are also in the opposite safelist or blocklist.
+	Conflicts []string `json:"conflicts,omitempty"`
+	// Released maps sources to the items whose reference was released, as the sources asked for
+	// their removal.
+	Released  map[string][]string `json:"released,omitempty"`
+	Counts    map[string]int      `json:"counts"`
+	Items     []ItemResult        `json:"items,omitempty"`
+	Responses []Response          `json:"responses,omitempty"`
+}
+
+// ItemResult is the outcome of a single item.
+type ItemResult struct {
+	Value  string `json:"value"`
+	Result string `json:"result"`
+}
+
+// Response is a summary of a response received from SMC.
+type Response struct {
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	Body   string    `json:"body,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Done reports whether processing of the batch has finished.
+func (r Record) Done() bool {
+	return r.State == Success || r.State == Failed || r.State == Partial || r.State == Planned
+}
+
+// Summary returns the record without its per-item details.
+func (r Record) Summary() Record {
+	r.Lists = summarize(r.Lists)
+	r.Applied = nil
+	if r.Rollback != nil {
+		rollback := *r.Rollback
+		rollback.Lists = summarize(rollback.Lists)
+		r.Rollback = &rollback
+	}
+	return r
+}
+
+// Revertible reports whether the changes of the batch can be rolled back.
+func (r Record) Revertible() bool {
+	applied := r.State == Success || r.State == Partial
+	return applied && len(r.Applied) > 0 && (r.Rollback == nil || r.Rollback.State == Failed)
+}
+
+func summarize(results []ListResult) []ListResult {
+	lists := make([]ListResult, len(results))
+	for i, list := range results {
+		lists[i] = ListResult{List: list.List, Key: list.Key, CreateList: list.CreateList, Error: list.Error, Counts: list.Counts}
+	}
+	return lists
+}
+
+// saveDelay is the time changes to the history are collected for before they are persisted. Batches
+// being received and finishing are persisted right away.
+const saveDelay = time.Second
+
+var (
+	mu      sync.Mutex
+	records = map[int]*Record{}
+	// dirty is set while changes to the history wait to be persisted.
+	dirty bool
+	// interrupted are the batches which failed when the history was read and were not reported yet.
+	interrupted []int
+)
+
+// Load reads the batch history persisted in the state store. Batches which were queued or running
+// when the module stopped are lost with the module's memory, they fail so the controller can be told.
+// Deferred batches are replayed from the offline buffer instead, and batches waiting for an operator
+// are resumed once they were restored.
+func Load() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	loaded := map[int]*Record{}
+	if err := state.Load(stateSection, &loaded); err != nil {
+		return err
+	}
+	records = loaded
+
+	now := time.Now()
+	interrupted = nil
+	for id, record := range records {
+		if record.Done() || record.State == Deferred {
+			continue
+		}
+		logrus.Warnf("batch %d was %s when the module stopped and failed", id, record.State)
+		record.State = Failed
+		record.Interrupted = true
+		record.Finished = &now
+		interrupted = append(interrupted, id)
+	}
+	if len(interrupted) > 0 {
+		sort.Ints(interrupted)
+		flush()
+	}
+	return nil
+}
+
+// Resume records that a batch which failed as the module stopped is restored, as it waited for an
+// operator and was persisted with its request. It waits for the operator again.
+func Resume(id int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	record, ok := records[id]
+	if !ok || !record.Interrupted {
+		return
+	}
+	record.State = Pending
+	record.Interrupted = false
+	record.Finished = nil
+	for i, interruptedID := range interrupted {
+		if interruptedID == id {
+			interrupted = append(interrupted[:i], interrupted[i+1:]...)
+			break
+		}
+	}
+	save()
+}
+
+// TakeInterrupted returns the batches which failed when the history was read, once, so their status
+// is reported to the controller.
+func TakeInterrupted() []int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	ids := interrupted
+	interrupted = nil
+	return ids
+}
+
+// Register records the submission of a request. Duplicates return the record of the earlier
+// submission and are left untouched. Conflicting submissions are flagged and recorded as new once the
+// earlier submission finished, while it is still processed they are refused and the earlier record
+// is returned. A batch which was only planned by a dry run is recorded as new when it is submitted
+// again.
+func Register(request structs.Request) (Record, Outcome) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	hash := contentHash(request)
+	outcome := New
+	flagged := false
+	if record, ok := records[request.BatchID]; ok {
+		// A batch interrupted by a restart is processed again. Deferred batches are replayed from
+		// the offline buffer instead.
+		interrupted := record.Interrupted
+		switch {
+		case record.State == Planned:
+		case record.Hash != hash && !record.Done():
+			return *record, InFlight
+		case record.Hash != hash:
+			outcome = Conflict
+			flagged = true
+		case !interrupted:
+			return *record, Duplicate
+		}
+	}
+
+	itemCount := len(request.Items)
+	if request.UpdateType == structs.DELETE {
+		itemCount = 1
+	}
+	record := &Record{
+		ID:         request.BatchID,
+		Hash:       hash,
+		State:      Queued,
+		Flagged:    flagged,
+		DryRun:     request.DryRun,
+		UpdateType: request.UpdateType,
+		SafeList:   request.SafeList,
+		ItemCount:  itemCount,
+		Received:   time.Now(),
+	}
+	records[request.BatchID] = record
+	prune()
+	// A batch is persisted as soon as it was received, so it is not applied twice after a restart.
+	flush()
+	return *record, outcome
+}
+
+// SetState records the current state of a batch.
+func SetState(id int, batchState State) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	record, ok := records[id]
+	if !ok {
+		return
+	}
+	record.State = batchState
+	now := time.Now()
+	switch {
+	case batchState == Running && record.Started == nil:
+		record.Started = &now
+	case record.Done():
+		record.Finished = &now
+		flush()
+		return
+	}
+	save()
+}
+
+// AddListResult records the outcome of a batch for one of its lists. Item results beyond the
+// configured maximum are dropped, they are still included in the counts.
+func AddListResult(id int, result ListResult) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	record, ok := records[id]
+	if !ok {
+		return
+	}
+
+	if result.Key != "" && !record.DryRun {
+		record.Applied = appliedChange(record.Applied, result)
+	}
+	record.Lists = addResult(record.Lists, result)
+	save()
+}
+
+// appliedChange records the items a list result changed, replacing the changes of an earlier attempt.
+func appliedChange(applied []ListChange, result ListResult) []ListChange {
+	change := ListChange{Key: result.Key, List: result.List, Released: result.Released}
+	for _, item := range result.Items {
+		switch item.Result {
+		case ItemAdded:
+			change.Added = append(change.Added, item.Value)
+		case ItemRemoved:
+			change.Removed = append(change.Removed, item.Value)
+		case ItemPresent:
+			change.Present = append(change.Present, item.Value)
+		}
+	}
+
+	for i, existing := range applied {
+		if existing.Key == change.Key {
+			applied[i] = change
+			return applied
+		}
+	}
+	return append(applied, change)
+}
+
+// addResult adds a list result with its item results limited to the configured maximum. A replayed
+// batch replaces the results of its earlier attempt.
+func addResult(results []ListResult, result ListResult) []ListResult {
+	if max := viper.GetInt("batch_history_max_items"); len(result.Items) > max {
+		result.Items = result.Items[:max]
+	}
+
+	for i, list := range results {
+		if list.List == result.List {
+			results[i] = result
+			return results
+		}
+	}
+	return append(results, result)
+}
+
+// StartRollback records that the batch is being rolled back and returns its applied changes.
+// Items which other batches added or rely on are left out of the changes, so they stay in the lists.
+func StartRollback(id int) ([]ListChange, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	record, ok := records[id]
+	if !ok || !record.Revertible() {
+		return nil, ErrNotRevertible
+	}
+
+	var changes []ListChange
+	for _, change := range record.Applied {
+		claimed := claimedItems(id, change.Key)
+		kept := ListChange{Key: change.Key, List: change.List, Removed: change.Removed, Released: change.Released}
+		for _, item := range change.Added {
+			if _, ok := claimed[item]; !ok {
+				kept.Added = append(kept.Added, item)
+			}
+		}
+		changes = append(changes, kept)
+	}
+
+	record.Rollback = &Rollback{State: Queued, Requested: time.Now()}
+	flush()
+	return changes, nil
+}
+
+// claimedItems returns the items of a list which batches other than the given one added or rely on,
+// unless they were rolled back. The lock must be held.
+func claimedItems(id int, key string) map[string]struct{} {
+	claimed := map[string]struct{}{}
+	for _, record := range records {
+		if record.ID == id || (record.Rollback != nil && record.Rollback.State == Success) {
+			continue
+		}
+		for _, change := range record.Applied {
+			if change.Key != key {
+				continue
+			}
+			for _, item := range change.Added {
+				claimed[item] = struct{}{}
+			}
+			for _, item := range change.Present {
+				claimed[item] = struct{}{}
+			}
+		}
+	}
+	return claimed
+}
+
+// SetRollbackState records the current state of the rollback of a batch.
+func SetRollbackState(id int, rollbackState State) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	record, ok := records[id]
+	if !ok || record.Rollback == nil {
+		return
+	}
+	record.Rollback.State = rollbackState
+	if rollbackState == Success || rollbackState == Failed || rollbackState == Partial {
+		now := time.Now()
+		record.Rollback.Finished = &now
+		flush()
+		return
+	}
+	save()
+}
+
+// AddRollbackResult records the outcome of the rollback of a batch for one of its lists.
+func AddRollbackResult(id int, result ListResult) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	record, ok := records[id]
+	if !ok || record.Rollback == nil {
+		return
+	}
+	record.Rollback.Lists = addResult(record.Rollback.Lists, result)
+	save()
+}
+
+// Get returns the record of a batch.
+func Get(id int) (Record, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	record, ok := records[id]
+	if !ok {
+		return Record{}, false
+	}
+	return *record, true
+}
+
+// All returns every record, most recently received first.
+func All() []Record {
+	mu.Lock()
+	defer mu.Unlock()
+
+	all := make([]Record, 0, len(records))
+	for _, record := range records {
+		all = append(all, *record)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Received.After(all[j].Received)
+	})
+	return all
+}
+
+// prune drops the oldest records beyond the configured history size, and the changes applied by
+// batches which finished before the rollback window.
+func prune() {
+	if window := time.Duration(viper.GetInt("rollback_window_hours")) * time.Hour; window > 0 {
+		for _, record := range records {
+			if record.Applied != nil && record.Finished != nil && time.Since(*record.Finished) > window {
+				record.Applied = nil
+			}
+		}
+	}
+
+	size := viper.GetInt("batch_history_size")
+	if size < 1 || len(records) <= size {
+		return
+	}
+
+	ordered := make([]*Record, 0, len(records))
+	for _, record := range records {
+		ordered = append(ordered, record)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].Received.Before(ordered[j].Received)
+	})
+	for _, record := range ordered[:len(ordered)-size] {
+		delete(records, record.ID)
+	}
+}
+
+// save persists the history once the changes made within saveDelay were collected, so the results of
+// a batch's lists are not written one at a time. The lock must be held.
+func save() {
+	if dirty {
+		return
+	}
+	dirty = true
+	time.AfterFunc(saveDelay, func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if dirty {
+			flush()
+		}
+	})
+}
+
+// flush persists the history right away, along with the changes waiting for save. The lock must be
+// held.
+func flush() {
+	dirty = false
+	if err := state.Save(stateSection, records); err != nil {
+		logrus.Error("error persisting the batch history: ", err)
+	}
+}
+
+// contentHash identifies the content of a request independently of its encoding. A dry run of a
+// batch has the same content as the batch itself.
+func contentHash(request structs.Request) string {
+	request.BatchID = 0
+	request.DryRun = false
+	data, _ := json.Marshal(request)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"main/internal/state"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stateSection is the state store section the whole cache was persisted in by earlier versions. Each
+// list is now persisted in a section of its own, named after the section and the list's element ID,
+// so a change to one list does not rewrite the others.
+const stateSection = "list_cache"
+
+// List is the locally cached state of a single SMC list.
+type List struct {
+	Type    string    `json:"type"`
+	ETag    string    `json:"etag"`
+	Entries []string  `json:"entries"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// Index returns a set of the cached entries for repeated lookups.
+func (l List) Index() map[string]struct{} {
+	index := make(map[string]struct{}, len(l.Entries))
+	for _, e := range l.Entries {
+		index[e] = struct{}{}
+	}
+	return index
+}
+
+var (
+	mu    sync.Mutex
+	lists = map[string]List{}
+)
+
+// Load reads the cache persisted in the state store, splitting a cache persisted as a whole into a
+// section per list.
+func Load() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	legacy := map[string]List{}
+	if err := state.Load(stateSection, &legacy); err != nil {
+		return err
+	}
+	for id, list := range legacy {
+		if err := state.Save(listSection(id), list); err != nil {
+			return err
+		}
+	}
+	if err := state.Delete(stateSection); err != nil {
+		return err
+	}
+
+	sections, err := state.Sections(stateSection + ".")
+	if err != nil {
+		return err
+	}
+	loaded := map[string]List{}
+	for _, section := range sections {
+		id, err := url.PathUnescape(strings.TrimPrefix(section, stateSection+"."))
+		if err != nil {
+			continue
+		}
+		list := List{}
+		if err := state.Load(section, &list); err != nil {
+			return err
+		}
+		loaded[id] = list
+	}
+	lists = loaded
+	return nil
+}
+
+// Get returns the cached state of the list with the given SMC element ID. Lists without an ETag are
+// treated as stale and are not returned.
+func Get(id string) (List, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list, ok := lists[id]
+	if !ok || list.ETag == "" {
+		return List{}, false
+	}
+	return list, true
+}
+
+// Set replaces the cached state of a list. The entries slice must not be modified afterwards.
+func Set(id string, list List) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if list.Fetched.IsZero() {
+		list.Fetched = time.Now()
+	}
+	lists[id] = list
+	return save(id)
+}
+
+// Invalidate marks a list as stale so it is fetched from SMC before the next write.
+func Invalidate(id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list, ok := lists[id]
+	if !ok {
+		return nil
+	}
+	list.ETag = ""
+	lists[id] = list
+	return save(id)
+}
+
+// Remove drops a list from the cache entirely.
+func Remove(id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(lists, id)
+	return state.Delete(listSection(id))
+}
+
+// Types returns the SMC list type of every cached list, keyed by element ID.
+func Types() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	types := make(map[string]string, len(lists))
+	for id, list := range lists {
+		types[id] = list.Type
+	}
+	return types
+}
+
+// listSection returns the state store section a list is persisted in.
+func listSection(id string) string {
+	return stateSection + "." + url.PathEscape(id)
+}
+
+func save(id string) error {
+	return state.Save(listSection(id), lists[id])
+}
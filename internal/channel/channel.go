@@ -1,5 +1,50 @@
 package channel
 
-import "main/internal/structs"
+import (
+	"main/internal/structs"
+	"sync/atomic"
+)
 
 var Requests = make(chan structs.Request, 100)
+
+// depth is the number of accepted requests which have not finished processing.
+var depth int64
+
+// Init sizes the request queue. It must be called before any request is queued.
+func Init(capacity int) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	Requests = make(chan structs.Request, capacity)
+}
+
+// Enqueue queues a request without blocking. It returns false when the queue is full.
+func Enqueue(request structs.Request) bool {
+	if atomic.AddInt64(&depth, 1) > int64(cap(Requests)) {
+		atomic.AddInt64(&depth, -1)
+		return false
+	}
+
+	select {
+	case Requests <- request:
+		return true
+	default:
+		atomic.AddInt64(&depth, -1)
+		return false
+	}
+}
+
+// Done marks a queued request as processed.
+func Done() {
+	atomic.AddInt64(&depth, -1)
+}
+
+// Depth returns the number of queued requests which have not finished processing.
+func Depth() int {
+	return int(atomic.LoadInt64(&depth))
+}
+
+// Capacity returns the maximum number of requests which may be queued.
+func Capacity() int {
+	return cap(Requests)
+}
@@ -22,6 +22,7 @@ func InitConfig() {
 	// Initialise viper config using config.yml file.
 	viper.SetConfigName("config")
 	viper.AddConfigPath("./config")
+	setDefaults()
 
 	// If unable to read in config, exit.
 	if err := viper.ReadInConfig(); err != nil {
@@ -31,6 +32,82 @@ func InitConfig() {
 	viper.WatchConfig()
 }
 
+// setDefaults registers the values used for settings which are not present in config.yml.
+func setDefaults() {
+	// IP list batches at or above this size are uploaded through the SMC import endpoint.
+	viper.SetDefault("ip_import_threshold", 5000)
+	// Seconds between refreshes of the cached SMC list contents, 0 disables the periodic refresh.
+	viper.SetDefault("list_cache_refresh_interval", 900)
+	// Number of requests which may be queued before /run responds with 503, and the number of
+	// seconds the controller is told to wait before retrying.
+	viper.SetDefault("queue_capacity", 100)
+	viper.SetDefault("retry_after_seconds", 30)
+	// SMC is considered unreachable after this many failed requests in a row. Work is then held back,
+	// up to the buffer capacity, and SMC is probed at the given interval in seconds, at least one.
+	viper.SetDefault("breaker_failure_threshold", 5)
+	viper.SetDefault("breaker_probe_interval", 30)
+	viper.SetDefault("offline_buffer_capacity", 10000)
+	// Number of lists which are written to in parallel.
+	viper.SetDefault("worker_pool_size", 4)
+	// Updates to the same list arriving within the window are merged into a single write, up to
+	// the maximum number of items.
+	viper.SetDefault("coalesce_window_ms", 1000)
+	viper.SetDefault("coalesce_max_items", 10000)
+	// Number of processed batches remembered to detect resubmissions and report their status.
+	viper.SetDefault("batch_history_size", 1000)
+	// Number of item results kept per list of a batch, further items are only counted.
+	viper.SetDefault("batch_history_max_items", 1000)
+	// Hours after which a batch can no longer be rolled back and the changes it applied are dropped
+	// from the history. A window of 0 keeps them as long as the batch.
+	viper.SetDefault("rollback_window_hours", 168)
+	// Plan every request against the current SMC lists without changing them. Requests may also ask
+	// for a dry run themselves.
+	viper.SetDefault("dry_run", false)
+	// Precedence between safelists and blocklists: none only reports conflicting items, safelist
+	// removes safelisted items from the blocklist and refuses to blocklist them, blocklist the reverse.
+	viper.SetDefault("list_precedence", "none")
+	// Networks and domains which are never blocklisted, in addition to the SMC server and the DNS
+	// servers. Blocklisted networks must also be at least as specific as the minimum prefix lengths.
+	viper.SetDefault("protected_cidrs", []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8", "169.254.0.0/16", "::1/128", "fc00::/7", "fe80::/10"})
+	viper.SetDefault("protected_domains", []string{})
+	viper.SetDefault("blocklist_min_prefix_ipv4", 16)
+	viper.SetDefault("blocklist_min_prefix_ipv6", 48)
+	// Items refused by the protections above are rejected, or held for approval with approve.
+	viper.SetDefault("guardrail_action", "reject")
+	// Changes held until an operator approves them: networks broader than these prefix lengths, where 0
+	// disables the rule, domains on the watch-list and their subdomains, and removals from safelists.
+	viper.SetDefault("approval_min_prefix_ipv4", 0)
+	viper.SetDefault("approval_min_prefix_ipv6", 0)
+	viper.SetDefault("approval_watch_domains", []string{})
+	viper.SetDefault("approval_safelist_removals", false)
+	// Changes to a list are paused for an operator decision when the items added or removed within
+	// the window in seconds exceed these limits. A limit of 0 disables it.
+	viper.SetDefault("rate_limit_window", 60)
+	viper.SetDefault("rate_limit_max_adds", 20000)
+	viper.SetDefault("rate_limit_max_removals", 5000)
+	// Write the snort rules of each source to a managed rule file of its own instead of a shared one.
+	viper.SetDefault("snort_file_per_source", false)
+	// Dir the global snort configuration is backed up to before every import, and the number of
+	// backups kept.
+	viper.SetDefault("snort_backup_dir", "./config/snort_backups")
+	viper.SetDefault("snort_backup_max", 20)
+	// Local range snort rules without a SID are given one from, none by default.
+	viper.SetDefault("snort_sid_min", 0)
+	viper.SetDefault("snort_sid_max", 0)
+	// Warn about list entries which were not added by the module. Such entries are never removed.
+	viper.SetDefault("report_foreign_entries", false)
+	// Names of the firewall policies refreshed after a batch changed the lists, none by default.
+	viper.SetDefault("refresh_policies", []string{})
+	// Treat the entries already in a list when the module first tracks it as added by the module, so
+	// entries written before the module tracked their sources can be removed. Entries added to the lists
+	// by hand are then removed as well.
+	viper.SetDefault("adopt_existing_entries", false)
+	// Naming of the SMC lists. Templates may reference {prefix}, {type}, {source} and {description}.
+	viper.SetDefault("list_name_prefix", "")
+	viper.SetDefault("list_name_template", smc.DefaultListNameTemplate)
+	viper.SetDefault("list_comment_template", smc.DefaultListCommentTemplate)
+}
+
 func createConfig() {
 	// Create the config
 	f, err := os.Create("./config/config.yml")
@@ -74,6 +74,97 @@ func GetModuleDetails(loggedIn bool) structs.Module {
 		},
 	}
 
+	batchesEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/batches",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			getMethod,
+		},
+	}
+	batchEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/batches/{id}",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			getMethod,
+		},
+	}
+
+	rollbackEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/batches/{id}/rollback",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			postMethod,
+		},
+	}
+	approvalsEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/approvals",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			getMethod,
+		},
+	}
+	approvalEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/approvals/{id}",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			postMethod,
+		},
+	}
+	holdsEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/holds",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			getMethod,
+			postMethod,
+		},
+	}
+	snortBackupsEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/snort/backups",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			getMethod,
+		},
+	}
+	snortRestoreEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/snort/backups/{id}/restore",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			postMethod,
+		},
+	}
+	provenanceEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/provenance",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			getMethod,
+		},
+	}
+	planEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/plan",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			postMethod,
+		},
+	}
+	eventsEndpoint := structs.InternalEndpoint{
+		Secure:   true,
+		Endpoint: "/events",
+		HttpMethods: []structs.Method{
+			optionsMethod,
+			getMethod,
+		},
+	}
+
 	// Create Module
 	module := structs.Module{
 		ServiceName:  "fp-smc",
@@ -90,6 +181,17 @@ func GetModuleDetails(loggedIn bool) structs.Module {
 			runEndpoint,
 			healthEndpoint,
 			configEndpoint,
+			batchesEndpoint,
+			batchEndpoint,
+			rollbackEndpoint,
+			approvalsEndpoint,
+			approvalEndpoint,
+			holdsEndpoint,
+			snortBackupsEndpoint,
+			snortRestoreEndpoint,
+			provenanceEndpoint,
+			planEndpoint,
+			eventsEndpoint,
 		},
 		AcceptedElementTypes: structs.ModuleElementTypes{
 			ElementTypes: []structs.ListElementType{structs.IP, structs.RANGE, structs.DOMAIN, structs.URL, structs.SNORT},
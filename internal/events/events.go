@@ -0,0 +1,101 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Type = string
+
+const (
+	// Queued batches were accepted and are waiting for a worker.
+	Queued Type = "queued"
+	// Started batches have a job running.
+	Started Type = "started"
+	// ListCompleted is sent when a batch has been applied to one of its lists.
+	ListCompleted Type = "list_completed"
+	// Retry is sent when a change is attempted again.
+	Retry Type = "retry"
+	// Deferred batches are held back until SMC can be reached.
+	Deferred Type = "deferred"
+	// Approval is sent when a change is held for an operator's approval, and once it was decided.
+	Approval Type = "approval"
+	// PolicyRefresh is sent when a policy was refreshed after its lists changed.
+	PolicyRefresh Type = "policy_refresh"
+	// Finished batches have reported their final status.
+	Finished Type = "finished"
+)
+
+// subscriberBuffer is the number of events held for a subscriber which is not keeping up.
+const subscriberBuffer = 256
+
+// Event is a step in the processing of one or more batches.
+type Event struct {
+	ID      uint64         `json:"id"`
+	Type    Type           `json:"type"`
+	Batches []int          `json:"batches,omitempty"`
+	List    string         `json:"list,omitempty"`
+	Status  string         `json:"status,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Counts  map[string]int `json:"counts,omitempty"`
+	Time    time.Time      `json:"time"`
+}
+
+// Matches reports whether the event concerns the given batch. Every event matches batch 0.
+func (e Event) Matches(batchID int) bool {
+	if batchID == 0 {
+		return true
+	}
+	for _, id := range e.Batches {
+		if id == batchID {
+			return true
+		}
+	}
+	return false
+}
+
+type subscriber struct {
+	events  chan Event
+	batchID int
+}
+
+var (
+	mu          sync.Mutex
+	lastID      uint64
+	subscribers = map[*subscriber]struct{}{}
+)
+
+// Subscribe returns a channel receiving the events of the given batch, or of every batch for 0.
+// Events are dropped for subscribers which do not keep up. The returned function ends the
+// subscription.
+func Subscribe(batchID int) (<-chan Event, func()) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := &subscriber{events: make(chan Event, subscriberBuffer), batchID: batchID}
+	subscribers[s] = struct{}{}
+	return s.events, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(subscribers, s)
+	}
+}
+
+// Publish sends an event to every interested subscriber without waiting for them.
+func Publish(event Event) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lastID++
+	event.ID = lastID
+	event.Time = time.Now()
+	for s := range subscribers {
+		if !event.Matches(s.batchID) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
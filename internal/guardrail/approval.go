@@ -0,0 +1,72 @@
+package guardrail
+
+import (
+	"fmt"
+	"main/internal/structs"
+	"net"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// ActionReject refuses items which must not be blocklisted.
+	ActionReject = "reject"
+	// ActionApprove holds items which must not be blocklisted until an operator approves them.
+	ActionApprove = "approve"
+)
+
+// ApproveProtected reports whether protected items are held for approval instead of being refused.
+func ApproveProtected() bool {
+	return viper.GetString("guardrail_action") == ActionApprove
+}
+
+// Rules decide which changes are sensitive enough to wait for an operator's approval.
+type Rules struct {
+	domains          []string
+	safelistRemovals bool
+}
+
+// ApprovalRules returns the configured approval rules.
+func ApprovalRules() *Rules {
+	r := &Rules{safelistRemovals: viper.GetBool("approval_safelist_removals")}
+	for _, domain := range viper.GetStringSlice("approval_watch_domains") {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			r.domains = append(r.domains, domain)
+		}
+	}
+	return r
+}
+
+// Check returns why a change to an item needs an operator's approval, or an empty string if it can
+// be applied right away. Removals are checked when removal is set.
+func (r *Rules) Check(item structs.RequestElement, safe, removal bool) string {
+	value := item.Normalized()
+	if removal && safe && r.safelistRemovals {
+		return fmt.Sprintf("%s is removed from a safelist", value)
+	}
+
+	switch item.Type {
+	case structs.IP, structs.RANGE:
+		if removal {
+			return ""
+		}
+		first, last, ok := bounds(value)
+		if !ok {
+			return ""
+		}
+		return checkBreadth(value, first, last, minPrefix(first, "approval_min_prefix_ipv4", "approval_min_prefix_ipv6"))
+	case structs.URL, structs.DOMAIN:
+		host := hostOf(value)
+		if net.ParseIP(host) != nil {
+			return ""
+		}
+		for _, domain := range r.domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return fmt.Sprintf("%s is on the watch-list domain %s", value, domain)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,247 @@
+package guardrail
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"main/internal/structs"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// resolvConf is read for the DNS servers, which are always protected.
+const resolvConf = "/etc/resolv.conf"
+
+// Set is the set of networks and domains which must never be blocklisted.
+type Set struct {
+	networks []*net.IPNet
+	domains  []string
+}
+
+// protected is the protected set resolved by Refresh.
+var protected struct {
+	sync.RWMutex
+	set *Set
+}
+
+// Protected returns the protected set resolved by the last Refresh, resolving it first if needed.
+func Protected() *Set {
+	protected.RLock()
+	s := protected.set
+	protected.RUnlock()
+	if s != nil {
+		return s
+	}
+	return Refresh()
+}
+
+// Refresh resolves the configured protected set, extended with the SMC server and the DNS servers.
+// It is resolved once at startup and whenever the configuration changes, so requests do not depend
+// on DNS.
+func Refresh() *Set {
+	s := resolve()
+
+	protected.Lock()
+	defer protected.Unlock()
+	protected.set = s
+	return s
+}
+
+func resolve() *Set {
+	s := &Set{}
+	for _, cidr := range viper.GetStringSlice("protected_cidrs") {
+		s.addNetwork(cidr)
+	}
+	for _, domain := range viper.GetStringSlice("protected_domains") {
+		s.addDomain(domain)
+	}
+
+	// The SMC server is protected by name and by address.
+	if endpoint, err := url.Parse(viper.GetString("smc_endpoint")); err == nil && endpoint.Hostname() != "" {
+		host := endpoint.Hostname()
+		if ip := net.ParseIP(host); ip != nil {
+			s.addNetwork(host)
+		} else {
+			s.addDomain(host)
+			addrs, err := net.LookupIP(host)
+			if err != nil {
+				logrus.Warnf("the SMC endpoint %s could not be resolved to protect its address: %s", host, err)
+			}
+			for _, addr := range addrs {
+				s.addNetwork(addr.String())
+			}
+		}
+	}
+
+	for _, server := range dnsServers() {
+		s.addNetwork(server)
+	}
+	return s
+}
+
+func (s *Set) addNetwork(value string) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			value = fmt.Sprintf("%s/%d", value, bits)
+		}
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		logrus.Warnf("ignoring protected network %s which could not be parsed", value)
+		return
+	}
+	s.networks = append(s.networks, network)
+}
+
+func (s *Set) addDomain(domain string) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain != "" {
+		s.domains = append(s.domains, domain)
+	}
+}
+
+// Check returns why an item must not be blocklisted, or an empty string if it may be.
+func (s *Set) Check(item structs.RequestElement) string {
+	value := item.Normalized()
+	switch item.Type {
+	case structs.IP, structs.RANGE:
+		first, last, ok := bounds(value)
+		if !ok {
+			return ""
+		}
+		if reason := s.checkRange(first, last); reason != "" {
+			return reason
+		}
+		return checkBreadth(value, first, last, minPrefix(first, "blocklist_min_prefix_ipv4", "blocklist_min_prefix_ipv6"))
+	case structs.URL, structs.DOMAIN:
+		host := hostOf(value)
+		if ip := normalize(net.ParseIP(host)); ip != nil {
+			return s.checkRange(ip, ip)
+		}
+		for _, domain := range s.domains {
+			// Blocking a parent domain blocks the protected domain as well.
+			if host == domain || strings.HasSuffix(host, "."+domain) || strings.HasSuffix(domain, "."+host) {
+				return fmt.Sprintf("%s overlaps the protected domain %s", value, domain)
+			}
+		}
+	}
+	return ""
+}
+
+// checkRange returns why the addresses from first to last overlap a protected network.
+func (s *Set) checkRange(first, last net.IP) string {
+	for _, network := range s.networks {
+		start, end := networkBounds(network)
+		if len(start) != len(first) {
+			continue
+		}
+		if bytes.Compare(first, end) <= 0 && bytes.Compare(start, last) <= 0 {
+			return fmt.Sprintf("%s - %s overlaps the protected network %s", first, last, network)
+		}
+	}
+	return ""
+}
+
+// minPrefix returns the prefix length configured under the key for the address family of ip.
+func minPrefix(ip net.IP, ipv4Key, ipv6Key string) int {
+	if len(ip) == net.IPv4len {
+		return viper.GetInt(ipv4Key)
+	}
+	return viper.GetInt(ipv6Key)
+}
+
+// checkBreadth returns why the addresses from first to last cover more than the minimum prefix length
+// allows.
+func checkBreadth(value string, first, last net.IP, minPrefix int) string {
+	// The addresses fit in a prefix of the minimum length if they only differ in its host bits.
+	for bit := 0; bit < minPrefix && bit < 8*len(first); bit++ {
+		mask := byte(0x80 >> uint(bit%8))
+		if first[bit/8]&mask != last[bit/8]&mask {
+			return fmt.Sprintf("%s is broader than the minimum prefix length /%d", value, minPrefix)
+		}
+	}
+	return ""
+}
+
+// bounds returns the first and last address of an IP, CIDR or range value.
+func bounds(value string) (net.IP, net.IP, bool) {
+	if parts := strings.Split(value, "-"); len(parts) == 2 {
+		first, last := normalize(net.ParseIP(parts[0])), normalize(net.ParseIP(parts[1]))
+		if first == nil || last == nil || len(first) != len(last) {
+			return nil, nil, false
+		}
+		return first, last, true
+	}
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		first, last := networkBounds(network)
+		return first, last, true
+	}
+	if ip := normalize(net.ParseIP(value)); ip != nil {
+		return ip, ip, true
+	}
+	return nil, nil, false
+}
+
+// networkBounds returns the first and last address of a network.
+func networkBounds(network *net.IPNet) (net.IP, net.IP) {
+	first := normalize(network.IP.Mask(network.Mask))
+	mask := network.Mask
+	if len(mask) != len(first) {
+		mask = mask[len(mask)-len(first):]
+	}
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^mask[i]
+	}
+	return first, last
+}
+
+// normalize returns IPv4 addresses in their 4 byte form so they compare with IPv4 networks.
+func normalize(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+// hostOf returns the host of a URL or domain value.
+func hostOf(value string) string {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return strings.ToLower(value)
+	}
+	return strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+}
+
+// dnsServers returns the name servers configured for the module.
+func dnsServers() []string {
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
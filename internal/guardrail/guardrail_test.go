@@ -0,0 +1,63 @@
+package guardrail
+
+import (
+	"main/internal/structs"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSetCheck(t *testing.T) {
+	s := &Set{}
+	for _, network := range []string{"10.0.0.0/8", "192.168.1.1", "fc00::/7"} {
+		s.addNetwork(network)
+	}
+	s.addDomain("Example.com.")
+
+	viper.Set("blocklist_min_prefix_ipv4", 16)
+	viper.Set("blocklist_min_prefix_ipv6", 48)
+	defer viper.Set("blocklist_min_prefix_ipv4", nil)
+	defer viper.Set("blocklist_min_prefix_ipv6", nil)
+
+	tests := []struct {
+		name      string
+		kind      structs.ListElementType
+		value     string
+		protected bool
+	}{
+		{name: "ip in protected network", kind: structs.IP, value: "10.1.2.3", protected: true},
+		{name: "protected host", kind: structs.IP, value: "192.168.1.1", protected: true},
+		{name: "ip next to protected host", kind: structs.IP, value: "192.168.1.2"},
+		{name: "public ip", kind: structs.IP, value: "8.8.8.8"},
+		{name: "ipv4 mapped ipv6 ip", kind: structs.IP, value: "::ffff:10.1.2.3", protected: true},
+		{name: "ipv6 ip in protected network", kind: structs.IP, value: "fd00::1", protected: true},
+		{name: "public ipv6 ip", kind: structs.IP, value: "2001:db8::1"},
+		{name: "cidr inside protected network", kind: structs.IP, value: "10.1.0.0/16", protected: true},
+		{name: "cidr covering protected network", kind: structs.IP, value: "8.0.0.0/6", protected: true},
+		{name: "public cidr", kind: structs.IP, value: "8.8.0.0/16"},
+		{name: "cidr broader than minimum prefix", kind: structs.IP, value: "8.0.0.0/15", protected: true},
+		{name: "ipv6 cidr broader than minimum prefix", kind: structs.IP, value: "2001:db8::/32", protected: true},
+		{name: "range overlapping protected network", kind: structs.RANGE, value: "9.255.255.250-10.0.0.5", protected: true},
+		{name: "public range", kind: structs.RANGE, value: "8.8.8.1-8.8.8.20"},
+		{name: "range broader than minimum prefix", kind: structs.RANGE, value: "8.8.0.0-8.9.0.0", protected: true},
+		{name: "url with protected ip host", kind: structs.URL, value: "http://10.1.2.3/x", protected: true},
+		{name: "url with public ip host", kind: structs.URL, value: "http://8.8.8.8/x"},
+		{name: "url with protected ipv6 host", kind: structs.URL, value: "http://[fd00::1]/x", protected: true},
+		{name: "domain of protected ip", kind: structs.DOMAIN, value: "10.1.2.3", protected: true},
+		{name: "protected domain", kind: structs.DOMAIN, value: "example.com", protected: true},
+		{name: "subdomain of protected domain", kind: structs.DOMAIN, value: "www.Example.com", protected: true},
+		{name: "parent of protected domain", kind: structs.DOMAIN, value: "com", protected: true},
+		{name: "url on protected domain", kind: structs.URL, value: "https://mail.example.com/inbox", protected: true},
+		{name: "unrelated domain", kind: structs.DOMAIN, value: "example.org"},
+		{name: "domain sharing a suffix", kind: structs.DOMAIN, value: "badexample.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason := s.Check(structs.RequestElement{Type: test.kind, Value: test.value})
+			if (reason != "") != test.protected {
+				t.Errorf("Check(%s) = %q, want protected %t", test.value, reason, test.protected)
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package provenance
+
+import (
+	"main/internal/state"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stateSection is the state store section the provenance of list entries was persisted in by
+// earlier versions, along with foreignSection. The provenance of each list is now persisted in a
+// section of its own, named after the section and the list's key, so a change to one list does not
+// rewrite the others.
+const stateSection = "provenance"
+
+// foreignSection is the state store section the entries which were already in a list before the
+// module added them were persisted in by earlier versions.
+const foreignSection = "foreign_entries"
+
+// LegacySource is the source recorded for the entries which were in a list before the module
+// tracked the sources of entries.
+const LegacySource = "legacy"
+
+// maxBatches is the number of batches remembered per reference.
+const maxBatches = 10
+
+// Ref is a source which added an entry to a list, with the batches it was added in, most recent last.
+type Ref struct {
+	Source  string    `json:"source"`
+	Batches []int     `json:"batches,omitempty"`
+	Added   time.Time `json:"added"`
+}
+
+// Entry is the provenance of a value in one list.
+type Entry struct {
+	List  string `json:"list"`
+	Value string `json:"value"`
+	Refs  []Ref  `json:"refs"`
+	// Foreign entries were already in the list when the module added them, they are never removed.
+	Foreign bool `json:"foreign,omitempty"`
+}
+
+// listProvenance is the provenance of the values of one list.
+type listProvenance struct {
+	// Refs maps values to the sources which added them.
+	Refs map[string][]Ref `json:"refs"`
+	// Foreign are the values which were added outside of the module.
+	Foreign map[string]bool `json:"foreign,omitempty"`
+	// Adopted is set once the entries which predate provenance tracking were recorded.
+	Adopted bool `json:"adopted,omitempty"`
+}
+
+var (
+	mu sync.Mutex
+	// lists maps list keys to the provenance of their values.
+	lists = map[string]*listProvenance{}
+)
+
+// Load reads the provenance persisted in the state store, splitting provenance persisted as a whole
+// into a section per list.
+func Load() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := migrate(); err != nil {
+		return err
+	}
+
+	sections, err := state.Sections(stateSection + ".")
+	if err != nil {
+		return err
+	}
+	loaded := map[string]*listProvenance{}
+	for _, section := range sections {
+		key, err := url.PathUnescape(strings.TrimPrefix(section, stateSection+"."))
+		if err != nil {
+			continue
+		}
+		l := &listProvenance{}
+		if err := state.Load(section, l); err != nil {
+			return err
+		}
+		if l.Refs == nil {
+			l.Refs = map[string][]Ref{}
+		}
+		loaded[key] = l
+	}
+	lists = loaded
+	return nil
+}
+
+// migrate moves the provenance persisted as a whole into a section per list.
+func migrate() error {
+	refs := map[string]map[string][]Ref{}
+	if err := state.Load(stateSection, &refs); err != nil {
+		return err
+	}
+	foreign := map[string]map[string]bool{}
+	if err := state.Load(foreignSection, &foreign); err != nil {
+		return err
+	}
+
+	migrated := map[string]*listProvenance{}
+	for key, values := range refs {
+		migrated[key] = &listProvenance{Refs: values}
+	}
+	for key, values := range foreign {
+		if migrated[key] == nil {
+			migrated[key] = &listProvenance{Refs: map[string][]Ref{}}
+		}
+		migrated[key].Foreign = values
+	}
+	for key, l := range migrated {
+		if err := state.Save(listSection(key), l); err != nil {
+			return err
+		}
+	}
+
+	if err := state.Delete(stateSection); err != nil {
+		return err
+	}
+	return state.Delete(foreignSection)
+}
+
+// list returns the provenance of a list, creating it when missing. The lock must be held.
+func list(key string) *listProvenance {
+	l, ok := lists[key]
+	if !ok {
+		l = &listProvenance{Refs: map[string][]Ref{}}
+		lists[key] = l
+	}
+	return l
+}
+
+// refs returns the references to a value of a list. The lock must be held.
+func refs(key, value string) []Ref {
+	if l, ok := lists[key]; ok {
+		return l.Refs[value]
+	}
+	return nil
+}
+
+// isForeign reports whether a value of a list was added outside of the module. The lock must be held.
+func isForeign(key, value string) bool {
+	if l, ok := lists[key]; ok {
+		return l.Foreign[value]
+	}
+	return false
+}
+
+// Record records that sources added values to a list in a batch, once the values were written to
+// it. Values which were already in the list before any source referenced them were added outside
+// of the module and are recorded as foreign, the added values are recorded as the module's own.
+func Record(key string, batchID int, sources map[string][]string, added map[string]bool) {
+	if len(sources) == 0 {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	l := list(key)
+	untracked := map[string]bool{}
+	for _, values := range sources {
+		for _, value := range values {
+			if len(l.Refs[value]) == 0 {
+				untracked[value] = true
+			}
+		}
+	}
+
+	for source, values := range sources {
+		for _, value := range values {
+			refs := l.Refs[value]
+			i := refIndex(refs, source)
+			if i < 0 {
+				refs = append(refs, Ref{Source: source, Added: time.Now()})
+				i = len(refs) - 1
+			}
+			if batchID != 0 && !containsBatch(refs[i].Batches, batchID) {
+				refs[i].Batches = append(refs[i].Batches, batchID)
+				if len(refs[i].Batches) > maxBatches {
+					refs[i].Batches = refs[i].Batches[len(refs[i].Batches)-maxBatches:]
+				}
+			}
+			l.Refs[value] = refs
+
+			switch {
+			case added[value]:
+				delete(l.Foreign, value)
+			case untracked[value]:
+				if l.Foreign == nil {
+					l.Foreign = map[string]bool{}
+				}
+				l.Foreign[value] = true
+			}
+		}
+	}
+	save(key)
+}
+
+// Adopted reports whether the entries of a list which predate provenance tracking were recorded.
+func Adopted(key string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l, ok := lists[key]
+	return ok && l.Adopted
+}
+
+// Adopt records the values of a list without any reference, which are not known to be added outside
+// of the module, under the legacy source, so they are removed like the module's own. This is only
+// done when the operator vouches that the module added the entries of the list before it tracked
+// their sources. A list is only adopted once, lists created by the module are adopted without values.
+func Adopt(key string, values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l := list(key)
+	if l.Adopted {
+		return
+	}
+	adopted := 0
+	for _, value := range values {
+		if len(l.Refs[value]) > 0 || l.Foreign[value] {
+			continue
+		}
+		l.Refs[value] = []Ref{{Source: LegacySource, Added: time.Now()}}
+		adopted++
+	}
+	l.Adopted = true
+	save(key)
+	if adopted > 0 {
+		logrus.Infof("recorded %d entries of list %s which predate provenance tracking under the %s source", adopted, key, LegacySource)
+	}
+}
+
+// Release removes the reference of a source to values of a list and returns the values which are no
+// longer referenced by any source.
+func Release(key, source string, values ...string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l := list(key)
+	var unreferenced []string
+	for _, value := range values {
+		refs := l.Refs[value]
+		if i := refIndex(refs, source); i >= 0 {
+			refs = append(refs[:i:i], refs[i+1:]...)
+		}
+		if len(refs) == 0 {
+			delete(l.Refs, value)
+			unreferenced = append(unreferenced, value)
+			continue
+		}
+		l.Refs[value] = refs
+	}
+	save(key)
+	return unreferenced
+}
+
+// Forget drops every reference to values of a list.
+func Forget(key string, values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l := list(key)
+	for _, value := range values {
+		delete(l.Refs, value)
+	}
+	save(key)
+}
+
+// Referenced reports whether sources other than the given ones reference a value of a list.
+func Referenced(key, value string, sources ...string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	released := map[string]bool{}
+	for _, source := range sources {
+		released[source] = true
+	}
+	for _, ref := range refs(key, value) {
+		if !released[ref.Source] {
+			return true
+		}
+	}
+	return false
+}
+
+// ReleaseBatch removes a batch from the references to values of a list, dropping references which
+// were only made by that batch. The values which are no longer referenced are returned.
+func ReleaseBatch(key string, batchID int, values ...string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l := list(key)
+	var unreferenced []string
+	for _, value := range values {
+		kept := withoutBatch(l.Refs[value], batchID)
+		if len(kept) == 0 {
+			delete(l.Refs, value)
+			unreferenced = append(unreferenced, value)
+			continue
+		}
+		l.Refs[value] = kept
+	}
+	save(key)
+	return unreferenced
+}
+
+// UnreferencedBatch returns the values which ReleaseBatch would return, without releasing them.
+func UnreferencedBatch(key string, batchID int, values ...string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var unreferenced []string
+	for _, value := range values {
+		if len(withoutBatch(refs(key, value), batchID)) == 0 {
+			unreferenced = append(unreferenced, value)
+		}
+	}
+	return unreferenced
+}
+
+// withoutBatch returns the references with a batch removed, dropping references which were only
+// made by that batch.
+func withoutBatch(refs []Ref, batchID int) []Ref {
+	var kept []Ref
+	for _, ref := range refs {
+		if !containsBatch(ref.Batches, batchID) {
+			kept = append(kept, ref)
+			continue
+		}
+		var batches []int
+		for _, id := range ref.Batches {
+			if id != batchID {
+				batches = append(batches, id)
+			}
+		}
+		if len(batches) > 0 {
+			ref.Batches = batches
+			kept = append(kept, ref)
+		}
+	}
+	return kept
+}
+
+// Owned returns the values of a list which the module added. Values without any reference, or which
+// were already in the list when the module added them, were added outside of the module.
+func Owned(key string, values ...string) map[string]bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	owned := map[string]bool{}
+	for _, value := range values {
+		if len(refs(key, value)) > 0 && !isForeign(key, value) {
+			owned[value] = true
+		}
+	}
+	return owned
+}
+
+// Lookup returns the provenance of a value in every list it was added to.
+func Lookup(value string) []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var found []Entry
+	for key, l := range lists {
+		if refs, ok := l.Refs[value]; ok {
+			found = append(found, Entry{List: key, Value: value, Refs: append([]Ref(nil), refs...), Foreign: l.Foreign[value]})
+			continue
+		}
+		if l.Foreign[value] {
+			found = append(found, Entry{List: key, Value: value, Foreign: true})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].List < found[j].List
+	})
+	return found
+}
+
+func refIndex(refs []Ref, source string) int {
+	for i, ref := range refs {
+		if ref.Source == source {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsBatch(batches []int, batchID int) bool {
+	for _, id := range batches {
+		if id == batchID {
+			return true
+		}
+	}
+	return false
+}
+
+// listSection returns the state store section the provenance of a list is persisted in.
+func listSection(key string) string {
+	return stateSection + "." + url.PathEscape(key)
+}
+
+// save persists the provenance of a list. The lock must be held.
+func save(key string) {
+	if err := state.Save(listSection(key), lists[key]); err != nil {
+		logrus.Error("error persisting the entry provenance: ", err)
+	}
+}
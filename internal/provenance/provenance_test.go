@@ -0,0 +1,86 @@
+package provenance
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func reset() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lists = map[string]*listProvenance{}
+}
+
+func TestRecord(t *testing.T) {
+	reset()
+	const key = "blocklist"
+
+	// 1.1.1.1 is added by the module, 2.2.2.2 was already in the list before any source referenced it.
+	Record(key, 1, map[string][]string{"a": {"1.1.1.1", "2.2.2.2"}}, map[string]bool{"1.1.1.1": true})
+	if owned := Owned(key, "1.1.1.1", "2.2.2.2", "3.3.3.3"); !reflect.DeepEqual(owned, map[string]bool{"1.1.1.1": true}) {
+		t.Errorf("owned %v, want only the added value", owned)
+	}
+
+	// A value referenced by the module stays owned when another source finds it present.
+	Record(key, 2, map[string][]string{"b": {"1.1.1.1"}}, nil)
+	if !Owned(key, "1.1.1.1")["1.1.1.1"] {
+		t.Error("a value added by the module is no longer owned once another source references it")
+	}
+	if !Referenced(key, "1.1.1.1", "a") || Referenced(key, "1.1.1.1", "a", "b") {
+		t.Error("the references of both sources are not recorded")
+	}
+
+	// A foreign value the module adds later is owned.
+	Record(key, 3, map[string][]string{"a": {"2.2.2.2"}}, map[string]bool{"2.2.2.2": true})
+	if !Owned(key, "2.2.2.2")["2.2.2.2"] {
+		t.Error("a foreign value is not owned once the module added it")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	reset()
+	const key = "blocklist"
+
+	Record(key, 1, map[string][]string{"a": {"1.1.1.1", "2.2.2.2"}, "b": {"1.1.1.1"}}, map[string]bool{"1.1.1.1": true, "2.2.2.2": true})
+
+	unreferenced := Release(key, "a", "1.1.1.1", "2.2.2.2")
+	if !reflect.DeepEqual(unreferenced, []string{"2.2.2.2"}) {
+		t.Errorf("unreferenced %v, want the value only source a referenced", unreferenced)
+	}
+	if !Owned(key, "1.1.1.1")["1.1.1.1"] || Owned(key, "2.2.2.2")["2.2.2.2"] {
+		t.Error("releasing a source did not keep the values other sources reference")
+	}
+
+	if unreferenced := Release(key, "c", "1.1.1.1"); len(unreferenced) != 0 {
+		t.Errorf("releasing a source without a reference unreferenced %v", unreferenced)
+	}
+	if unreferenced := Release(key, "b", "1.1.1.1"); !reflect.DeepEqual(unreferenced, []string{"1.1.1.1"}) {
+		t.Errorf("unreferenced %v once the last source released the value", unreferenced)
+	}
+}
+
+func TestReleaseBatch(t *testing.T) {
+	reset()
+	const key = "blocklist"
+
+	Record(key, 1, map[string][]string{"a": {"1.1.1.1", "2.2.2.2"}}, map[string]bool{"1.1.1.1": true, "2.2.2.2": true})
+	Record(key, 2, map[string][]string{"a": {"1.1.1.1"}}, nil)
+
+	if unreferenced := UnreferencedBatch(key, 1, "1.1.1.1", "2.2.2.2"); !reflect.DeepEqual(unreferenced, []string{"2.2.2.2"}) {
+		t.Errorf("UnreferencedBatch returned %v", unreferenced)
+	}
+	if !Owned(key, "2.2.2.2")["2.2.2.2"] {
+		t.Error("UnreferencedBatch released the batch")
+	}
+
+	unreferenced := ReleaseBatch(key, 1, "1.1.1.1", "2.2.2.2")
+	sort.Strings(unreferenced)
+	if !reflect.DeepEqual(unreferenced, []string{"2.2.2.2"}) {
+		t.Errorf("ReleaseBatch returned %v, want the value only the batch referenced", unreferenced)
+	}
+	if !Referenced(key, "1.1.1.1") {
+		t.Error("a value another batch references was released")
+	}
+}
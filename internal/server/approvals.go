@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"main/internal/smc"
+	"main/internal/structs"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func listApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, smc.Approvals())
+}
+
+func decideApproval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "approval ID must be a number", http.StatusBadRequest)
+		return
+	}
+
+	var decision structs.ApprovalDecision
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if decision.Action != structs.Approve && decision.Action != structs.Reject {
+		http.Error(w, "action must be approve or reject", http.StatusBadRequest)
+		return
+	}
+
+	if err := smc.DecideApproval(id, decision.Action == structs.Approve); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"main/internal/batches"
+	"main/internal/smc"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func listBatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Batches are listed without their per-item results, which are returned for a single batch.
+	records := batches.All()
+	summaries := make([]batches.Record, len(records))
+	for i, record := range records {
+		summaries[i] = record.Summary()
+	}
+
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func getBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "batch ID must be a number", http.StatusBadRequest)
+		return
+	}
+
+	record, ok := batches.Get(id)
+	if !ok {
+		http.Error(w, "batch not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record)
+}
+
+// writeJSON writes a json response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logrus.Error("There was an error encoding the response: ", err)
+	}
+}
+
+func rollbackBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "batch ID must be a number", http.StatusBadRequest)
+		return
+	}
+	if _, ok := batches.Get(id); !ok {
+		http.Error(w, "batch not found", http.StatusNotFound)
+		return
+	}
+
+	switch err := smc.Rollback(id); errors.Cause(err) {
+	case nil:
+		w.WriteHeader(http.StatusAccepted)
+	case batches.ErrNotRevertible:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case smc.ErrNoSession:
+		w.Header().Set("Retry-After", viper.GetString("retry_after_seconds"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/internal/events"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// keepAliveInterval is how often a comment is sent to keep an idle event stream open.
+const keepAliveInterval = 15 * time.Second
+
+// streamEvents streams processing events as Server-Sent Events until the client disconnects. The
+// batch query parameter limits the stream to the events of a single batch.
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	batchID := 0
+	if batch := r.URL.Query().Get("batch"); batch != "" {
+		id, err := strconv.Atoi(batch)
+		if err != nil {
+			http.Error(w, "batch ID must be a number", http.StatusBadRequest)
+			return
+		}
+		batchID = id
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	stream, unsubscribe := events.Subscribe(batchID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event := <-stream:
+			data, err := json.Marshal(event)
+			if err != nil {
+				logrus.Error("There was an error encoding an event: ", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
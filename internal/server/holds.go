@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"main/internal/smc"
+	"main/internal/structs"
+	"net/http"
+)
+
+func holds(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, smc.Holds())
+	case http.MethodPost:
+		// Release or discard the changes held for a list.
+		var decision structs.HoldDecision
+		if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		switch decision.Action {
+		case structs.Release:
+			err = smc.ReleaseHold(decision.List)
+		case structs.Discard:
+			err = smc.DiscardHold(decision.List)
+		default:
+			http.Error(w, "action must be release or discard", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
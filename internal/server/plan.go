@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"main/internal/smc"
+	"main/internal/structs"
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// plan returns the SMC operations a request would result in, without queueing or applying it.
+func plan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request structs.Request
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The plan compares the request against the current lists, which requires SMC to be reachable.
+	if !smc.Available() {
+		w.Header().Set("Retry-After", viper.GetString("retry_after_seconds"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	// The plan waits for the changes queued before it, the client may give up on it meanwhile.
+	result, err := smc.Plan(r.Context(), request)
+	switch {
+	case r.Context().Err() != nil:
+		return
+	case err != nil:
+		w.Header().Set("Retry-After", viper.GetString("retry_after_seconds"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"main/internal/provenance"
+	"net/http"
+	"strings"
+)
+
+// getProvenance returns the sources and batches which added a value, for every list it is in.
+func getProvenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	value := strings.TrimSpace(r.URL.Query().Get("value"))
+	if value == "" {
+		http.Error(w, "a value is required", http.StatusBadRequest)
+		return
+	}
+
+	entries := provenance.Lookup(value)
+	if len(entries) == 0 {
+		http.Error(w, "value not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
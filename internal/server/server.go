@@ -17,7 +17,20 @@ import (
 
 func health(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
+		// Set response headers
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+		// Report the state of the request queue.
+		status := structs.Health{
+			SMCAvailable:     smc.Available(),
+			CircuitOpen:      smc.CircuitOpen(),
+			QueueDepth:       channel.Depth(),
+			QueueCapacity:    channel.Capacity(),
+			BufferedRequests: smc.Buffered(),
+		}
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			logrus.Error("There was an error encoding the health status for response: ", err)
+		}
 	} else {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -95,7 +108,14 @@ func run(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		channel.Requests <- request
+		// Tell the controller to retry later rather than holding the request open while SMC is
+		// unavailable or the queue is full. Requests are still accepted while SMC is unreachable,
+		// until the offline buffer is full.
+		if !smc.Accepting() || !channel.Enqueue(request) {
+			w.Header().Set("Retry-After", viper.GetString("retry_after_seconds"))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 
 		w.WriteHeader(http.StatusAccepted)
 
@@ -112,6 +132,17 @@ func RunServer(smcSession *smc.Session) {
 	router.HandleFunc("/health", health).Methods(http.MethodOptions, http.MethodGet)
 	router.Handle("/config", config(smcSession)).Methods(http.MethodOptions, http.MethodGet, http.MethodPost)
 	router.HandleFunc("/run", run).Methods(http.MethodOptions, http.MethodPost)
+	router.HandleFunc("/batches", listBatches).Methods(http.MethodOptions, http.MethodGet)
+	router.HandleFunc("/batches/{id}", getBatch).Methods(http.MethodOptions, http.MethodGet)
+	router.HandleFunc("/batches/{id}/rollback", rollbackBatch).Methods(http.MethodOptions, http.MethodPost)
+	router.HandleFunc("/approvals", listApprovals).Methods(http.MethodOptions, http.MethodGet)
+	router.HandleFunc("/approvals/{id}", decideApproval).Methods(http.MethodOptions, http.MethodPost)
+	router.HandleFunc("/holds", holds).Methods(http.MethodOptions, http.MethodGet, http.MethodPost)
+	router.HandleFunc("/snort/backups", listSnortBackups).Methods(http.MethodOptions, http.MethodGet)
+	router.HandleFunc("/snort/backups/{id}/restore", restoreSnortBackup).Methods(http.MethodOptions, http.MethodPost)
+	router.HandleFunc("/provenance", getProvenance).Methods(http.MethodOptions, http.MethodGet)
+	router.HandleFunc("/plan", plan).Methods(http.MethodOptions, http.MethodPost)
+	router.HandleFunc("/events", streamEvents).Methods(http.MethodOptions, http.MethodGet)
 
 	fmt.Println("Starting Server")
 
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"main/internal/smc"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+func listSnortBackups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, smc.SnortBackups())
+}
+
+func restoreSnortBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch err := smc.RestoreSnortBackup(mux.Vars(r)["id"]); errors.Cause(err) {
+	case nil:
+		w.WriteHeader(http.StatusAccepted)
+	case smc.ErrBackupNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case smc.ErrNoSession:
+		w.Header().Set("Retry-After", viper.GetString("retry_after_seconds"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
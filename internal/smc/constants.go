@@ -1,21 +1,21 @@
 package smc
 
 type ListType = string
-type LocalListName = string
-type Comment = string
+type ListKind = string
 
 const (
-	URLSafelist  LocalListName = "dim_url_safelist"
-	URLBlocklist LocalListName = "dim_url_blocklist"
-	IPSafelist   LocalListName = "dim_safelist"
-	IPBlocklist  LocalListName = "dim_blocklist"
+	URLSafelist  ListKind = "url_safelist"
+	URLBlocklist ListKind = "url_blocklist"
+	IPSafelist   ListKind = "safelist"
+	IPBlocklist  ListKind = "blocklist"
 
 	URLListType       ListType = "elements/url_list_application"
 	IPListType        ListType = "elements/ip_list"
 	IPAddressListType ListType = "ip_address_list"
 
-	URLBlocklistComment Comment = "URL/Domain Blocklist imported from the Dynamic Intelligence Manager."
-	URLSafelistComment  Comment = "URL/Domain Safelist imported from the Dynamic Intelligence Manager."
-	IPBlocklistComment  Comment = "IP Blocklist imported from the Dynamic Intelligence Manager."
-	IPSafelistComment   Comment = "IP Safelist imported from the Dynamic Intelligence Manager."
+	IPAddressListImportType ListType = "ip_address_list_import"
+	IPAddressListExportType ListType = "ip_address_list_export"
+
+	DefaultListNameTemplate    = "{prefix}dim_{type}"
+	DefaultListCommentTemplate = "{description} imported from the Dynamic Intelligence Manager."
 )
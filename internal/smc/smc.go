@@ -5,13 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"main/internal/batches"
 	"main/internal/channel"
+	"main/internal/guardrail"
+	"main/internal/provenance"
 	"main/internal/structs"
-	"main/internal/util"
 	"net/http"
 	"net/http/cookiejar"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -20,17 +21,24 @@ import (
 	"github.com/spf13/viper"
 )
 
+// ListParams describes the changes to apply to a single list. Items are added and removals removed.
 type ListParams struct {
-	UpdateType       structs.UpdateType
-	items            []string
-	item             string
-	safe             bool
-	batchID          int
-	listType         ListType
-	safelistName     LocalListName
-	blocklistName    LocalListName
-	safelistComment  Comment
-	blocklistComment Comment
+	items    []string
+	removals []string
+	safe     bool
+	listType ListType
+	list     managedList
+	report   *listReport
+	// dryRun plans the changes without writing to SMC.
+	dryRun bool
+	// conflicts is the check of the opposite list for the items, made on the opposite list's lane.
+	conflicts *conflictCheck
+	// releases maps removals to the sources which no longer reference them. They are only removed
+	// once no other source references them, and never when the module did not add them.
+	releases map[string][]string
+	// adders maps items to the sources which add them, so a coalesced removal of an item keeps it
+	// while another source still adds it.
+	adders map[string][]string
 }
 
 type Session struct {
@@ -101,40 +109,26 @@ func (s *Session) Login() int {
 	return status
 }
 
-func (s *Session) UpdateLists(params ListParams) {
-	switch params.UpdateType {
-	case structs.ADD:
+// UpdateLists applies the changes described by params to the targeted list, creating the list if required.
+func (s *Session) UpdateLists(params ListParams) error {
+	if len(params.items) > 0 {
 		created, err := s.createList(params)
 		if err != nil {
-			logrus.Error(err)
-			go s.updateBatchStatus(params.batchID, structs.Failed)
-			return
+			return err
 		}
 
 		// URL Lists require values to be passed for list creation, so in that case we can just return here
 		// as the next steps are unnecessary, but they will be run on subsequent updates.
 		if created && params.listType == URLListType {
-			go s.updateBatchStatus(params.batchID, structs.Success)
-			return
+			return nil
 		}
+	}
 
-		err = s.updateList(params)
-		if err != nil {
-			logrus.Error(err)
-			go s.updateBatchStatus(params.batchID, structs.Failed)
-			return
-		}
-	case structs.DELETE:
-		err := s.updateList(params)
-		if err != nil {
-			logrus.Error(err)
-			go s.updateBatchStatus(params.batchID, structs.Failed)
-			return
-		}
-	default:
-		// Build update to send to controller
-		go s.updateBatchStatus(params.batchID, structs.Success)
+	err := s.updateList(params)
+	if errors.Cause(err) == ErrListNotFound {
+		err = s.retryRecovered(params)
 	}
+	return err
 }
 
 func (s Session) Logout() {
@@ -171,7 +165,7 @@ func (s *Session) updateBatchStatus(id int, status string) {
 	json.NewEncoder(b).Encode(update)
 
 	url := fmt.Sprintf("http://%s:%s/internal/update", controllerSvcName, controllerPort)
-	_, resp, err := s.buildRequest(url, http.MethodPost, map[string]string{"x-internal-token": token, "Content-Type": "application/json"}, b)
+	_, resp, err := s.sendRequest(url, http.MethodPost, map[string]string{"x-internal-token": token, "Content-Type": "application/json"}, b)
 
 	if err != nil {
 		logrus.Error("There was an error sending the update to the controller: ", err)
@@ -184,120 +178,325 @@ func (s *Session) updateBatchStatus(id int, status string) {
 }
 
 func HandleRequests(session *Session) {
+	setActiveSession(session)
 	viper.OnConfigChange(func(in fsnotify.Event) {
-		session, _, _ = NewSMCSession(
+		session, _, _ := NewSMCSession(
 			viper.GetString("smc_endpoint"),
 			viper.GetString("smc_port"),
 			viper.GetString("smc_api_key"))
+		setActiveSession(session)
+		guardrail.Refresh()
+		// Apply changes to the list naming templates.
+		if session != nil && session.LoggedIn && workers != nil {
+			workers.verifyLists()
+		}
 	})
 
+	workers.restore()
+
+	// Hold work back until SMC can be reached if there was no session to start with, otherwise
+	// replay the work held back before a restart.
+	if session == nil && viper.GetString("smc_endpoint") != "" {
+		smcBreaker.trip()
+	} else {
+		go workers.replay()
+	}
+	if session != nil {
+		go reportInterrupted(session)
+	}
+
+	// The cached list contents are only refreshed periodically when an interval is configured.
+	var refreshes <-chan time.Time
+	if interval := time.Duration(viper.GetInt("list_cache_refresh_interval")) * time.Second; interval > 0 {
+		refresh := time.NewTicker(interval)
+		defer refresh.Stop()
+		refreshes = refresh.C
+	}
+
 	for {
+		select {
 		// Retrieve requests.
-		request := <-channel.Requests
-
-		switch request.UpdateType {
-		case structs.ADD:
-			var ips []string
-			var urls []string
-			var snorts []string
-
-			for _, item := range request.Items {
-				switch item.Type {
-				case structs.IP, structs.RANGE:
-					ips = append(ips, item.Value)
-				case structs.URL, structs.DOMAIN:
-					urls = append(urls, item.Value)
-				case structs.SNORT:
-					snorts = append(snorts, item.Value)
-				}
-			}
+		case request := <-channel.Requests:
+			workers.dispatch(request)
+		case <-refreshes:
+			workers.refreshListCache()
+		}
+	}
+}
 
-			// Send request to add to smc ip lists.
-			if len(ips) > 0 {
-				params := ListParams{
-					UpdateType:       request.UpdateType,
-					items:            ips,
-					safe:             request.SafeList,
-					batchID:          request.BatchID,
-					listType:         IPListType,
-					safelistName:     IPSafelist,
-					blocklistName:    IPBlocklist,
-					safelistComment:  IPSafelistComment,
-					blocklistComment: IPBlocklistComment,
-				}
-				session.UpdateLists(params)
+// reportInterrupted tells the controller about the batches which failed as the module stopped before
+// they finished.
+func reportInterrupted(session *Session) {
+	for _, id := range batches.TakeInterrupted() {
+		session.updateBatchStatus(id, structs.Failed)
+	}
+}
+
+// requestJobs splits a request into one job per list it writes to. Items are grouped by the list they
+// are written to, which depends on their source when the naming templates reference it.
+func requestJobs(request structs.Request) []job {
+	var jobs []job
+
+	switch request.UpdateType {
+	case structs.ADD:
+		lists := map[string]*ListParams{}
+		var order []string
+		var snorts []string
+		// Snort rules by the managed file they are written to.
+		snortFiles := map[string][]string{}
+		// Values added by each source to each list, for their provenance.
+		sources := map[string]map[string][]string{}
+		// Values refused for each list as they are protected from being blocklisted.
+		rejected := map[string][]string{}
+		// Values held for each list until an operator approves them.
+		held := map[string][]heldItem{}
+		var protected *guardrail.Set
+		if !request.SafeList {
+			protected = guardrail.Protected()
+		}
+		rules := guardrail.ApprovalRules()
+
+		for _, item := range request.Items {
+			var listType ListType
+			switch item.Type {
+			case structs.IP, structs.RANGE:
+				listType = IPListType
+			case structs.URL, structs.DOMAIN:
+				listType = URLListType
+			case structs.SNORT:
+				snorts = append(snorts, item.Normalized())
+				file := snortFile(elementSource(item.Source, item.ServiceName))
+				snortFiles[file] = append(snortFiles[file], item.Normalized())
+				continue
+			default:
+				continue
 			}
 
-			if len(urls) > 0 {
-				params := ListParams{
-					UpdateType:       request.UpdateType,
-					items:            urls,
-					safe:             request.SafeList,
-					batchID:          request.BatchID,
-					listType:         URLListType,
-					safelistName:     URLSafelist,
-					blocklistName:    URLBlocklist,
-					safelistComment:  URLSafelistComment,
-					blocklistComment: URLBlocklistComment,
+			source := elementSource(item.Source, item.ServiceName)
+			list := resolveList(kindFor(listType, request.SafeList), source)
+			params, ok := lists[list.key]
+			if !ok {
+				params = &ListParams{
+					safe:     request.SafeList,
+					listType: listType,
+					list:     list,
+					dryRun:   request.DryRun,
 				}
-				session.UpdateLists(params)
+				lists[list.key] = params
+				order = append(order, list.key)
 			}
+			reason := ""
+			if protected != nil {
+				reason = protected.Check(item)
+			}
+			if reason != "" && !guardrail.ApproveProtected() {
+				logrus.Warnf("refusing to blocklist %s: %s", item.Normalized(), reason)
+				rejected[list.key] = append(rejected[list.key], item.Normalized())
+				continue
+			}
+			if reason == "" {
+				reason = rules.Check(item, request.SafeList, false)
+			}
+			if reason != "" {
+				held[list.key] = append(held[list.key], heldItem{value: item.Normalized(), source: source, reason: reason})
+				continue
+			}
+			params.items = append(params.items, item.Normalized())
+			if sources[list.key] == nil {
+				sources[list.key] = map[string][]string{}
+			}
+			sources[list.key][source] = append(sources[list.key][source], item.Normalized())
+		}
 
-			if len(snorts) > 0 {
-				exportDirPath, err := session.RetrieveGlobalSnortConfig()
+		// Send requests to add to smc lists.
+		for _, key := range order {
+			params := lists[key]
+			if len(rejected[key]) > 0 {
+				jobs = append(jobs, keptJob(params.list, ItemProtected, rejected[key]...))
+			}
+			jobs = append(jobs, heldJobs(*params, held[key], request.BatchID)...)
+			if len(params.items) == 0 {
+				continue
+			}
+			jobs = append(jobs, additionJob(*params, sources[key], request.BatchID))
+			if j, ok := precedenceJob(*params); ok {
+				jobs = append(jobs, j)
+			}
+		}
 
-				if err != nil {
-					logrus.Error("Error in retrieving global snort config: ", err)
-				}
+		if len(snorts) > 0 {
+			jobs = append(jobs, job{
+				lane:  snortLane,
+				name:  snortLane,
+				items: snorts,
+				run: func(session *Session, report *listReport) error {
+					return session.addSnorts(snortFiles, request.DryRun, report)
+				},
+			})
+		}
 
-				snortFileName := fmt.Sprintf("%s%s%s", "dim_snorts_", strconv.FormatInt(time.Now().Unix(), 10), ".config")
+	case structs.DELETE:
+		var listType ListType
+		switch request.Item.Type {
+		case structs.IP, structs.RANGE:
+			listType = IPListType
+		case structs.URL, structs.DOMAIN:
+			listType = URLListType
+		case structs.SNORT:
+			jobs = append(jobs, snortRemovalJob(request.Item.Normalized(), request.DryRun))
+			return jobs
+		default:
+			return nil
+		}
 
-				err = util.SaveListToAfile(exportDirPath, snortFileName, snorts)
+		source := elementSource(request.Item.Source, request.Item.ServiceName)
+		list := resolveList(kindFor(listType, request.SafeList), source)
+		value := request.Item.Normalized()
+		remove := func() []job {
+			return []job{removalJob(ListParams{
+				removals: []string{value},
+				safe:     request.SafeList,
+				dryRun:   request.DryRun,
+				listType: listType,
+				list:     list,
+			}, source)}
+		}
 
-				if err != nil {
-					logrus.Error("Error in saving new snorts: ", err)
-				}
+		reason := guardrail.ApprovalRules().Check(request.Item, request.SafeList, true)
+		switch {
+		case reason == "":
+			jobs = append(jobs, remove()...)
+		case request.DryRun:
+			jobs = append(jobs, keptJob(list, ItemAwaitingApproval, value))
+		default:
+			jobs = append(jobs, gateJob(list, value, structs.DELETE, reason, request.BatchID, remove))
+		}
+	}
 
-				err = util.SmcRulesInclude(exportDirPath, snortFileName)
+	return jobs
+}
 
-				if err != nil {
-					logrus.Error("Error in adding the snort file to the rule include file", err)
-				}
+// removalJob returns a job removing a value which a source no longer references from its list. The
+// value is kept while other sources still reference it, and values the module did not add are never
+// removed, which is checked on the lane of the list. The reference of the source is only released
+// once the list was written.
+func removalJob(params ListParams, source string) job {
+	value := params.removals[0]
+	params.releases = map[string][]string{value: {source}}
+	j := listJob(params)
+	if !params.dryRun {
+		j.commit = func(batches.ListResult) {
+			provenance.Release(params.list.key, source, value)
+		}
+	}
+	return j
+}
 
-				err = session.ImportGlobalSnortConfig(exportDirPath)
+// keepReferenced leaves the removals out of params which other sources still reference or which the
+// module did not add, recording why they are kept.
+func keepReferenced(params ListParams) ListParams {
+	if len(params.releases) == 0 {
+		return params
+	}
 
-				if err != nil {
-					logrus.Error("Error in importing global snort config: ", err)
-				}
+	var removals []string
+	for _, value := range params.removals {
+		sources, released := params.releases[value]
+		switch {
+		case !released:
+			removals = append(removals, value)
+		case !provenance.Owned(params.list.key, value)[value]:
+			if viper.GetBool("report_foreign_entries") {
+				logrus.Warnf("%s was not added by the module and is kept in list %s", value, params.list.name)
 			}
+			params.report.result(ItemForeign, value)
+		case provenance.Referenced(params.list.key, value, sources...):
+			logrus.Infof("%s is still referenced by other sources and is kept in list %s", value, params.list.name)
+			params.report.result(ItemReferenced, value)
+		default:
+			removals = append(removals, value)
+		}
+	}
+	params.removals = removals
+	return params
+}
 
-			// clean up resources
-			ips = nil
-			urls = nil
-			snorts = nil
-
-		case structs.DELETE:
-			switch request.Item.Type {
-			case structs.URL, structs.DOMAIN:
-				params := ListParams{
-					UpdateType:       request.UpdateType,
-					item:             request.Item.Value,
-					safe:             request.SafeList,
-					batchID:          request.BatchID,
-					listType:         URLListType,
-					safelistName:     URLSafelist,
-					blocklistName:    URLBlocklist,
-					safelistComment:  URLSafelistComment,
-					blocklistComment: URLBlocklistComment,
+// additionJob returns a job adding the items of params to their list. The sources of the items
+// are recorded once they were written to the list.
+func additionJob(params ListParams, sources map[string][]string, batchID int) job {
+	params.adders = map[string][]string{}
+	for source, values := range sources {
+		for _, value := range values {
+			params.adders[value] = append(params.adders[value], source)
+		}
+	}
+	j := listJob(params)
+	if params.dryRun {
+		return j
+	}
+	j.commit = func(result batches.ListResult) {
+		written := map[string]bool{}
+		added := map[string]bool{}
+		for _, item := range result.Items {
+			switch item.Result {
+			case ItemAdded:
+				added[item.Value] = true
+				written[item.Value] = true
+			case ItemPresent:
+				written[item.Value] = true
+			}
+		}
+		recorded := map[string][]string{}
+		for source, values := range sources {
+			for _, value := range values {
+				if written[value] {
+					recorded[source] = append(recorded[source], value)
 				}
-				session.UpdateLists(params)
 			}
 		}
+		provenance.Record(params.list.key, batchID, recorded, added)
+	}
+	return j
+}
 
+// keptJob returns a job recording why values are left as they are in a list instead of being changed.
+func keptJob(list managedList, result string, values ...string) job {
+	return job{
+		lane:  list.key,
+		name:  list.name,
+		items: values,
+		run: func(session *Session, report *listReport) error {
+			report.result(result, values...)
+			return nil
+		},
+	}
+}
+
+// listJob returns a job applying params on the lane of the targeted list. Items added to the list
+// are checked against the opposite list first.
+func listJob(params ListParams) job {
+	if len(params.items) > 0 {
+		params.conflicts = newConflictCheck(params)
+	}
+	return job{
+		lane:   params.list.key,
+		name:   params.list.name,
+		params: &params,
 	}
 }
 
+// buildRequest sends a request to SMC. Requests fail immediately while the circuit breaker is open.
 func (s *Session) buildRequest(url, method string, headers map[string]string, data io.Reader) (int, *http.Response, error) {
+	if !smcBreaker.allow() {
+		return http.StatusServiceUnavailable, nil, ErrCircuitOpen
+	}
+
+	status, resp, err := s.sendRequest(url, method, headers, data)
+	smcBreaker.record(err, status)
+	return status, resp, err
+}
+
+func (s *Session) sendRequest(url, method string, headers map[string]string, data io.Reader) (int, *http.Response, error) {
 	// Build login request.
 	req, err := http.NewRequest(method, url, data)
 	if err != nil {
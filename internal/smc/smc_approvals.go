@@ -0,0 +1,167 @@
+package smc
+
+import (
+	"main/internal/events"
+	"main/internal/structs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrApprovalNotFound is returned when a change is decided which is not waiting for approval.
+var ErrApprovalNotFound = errors.New("the approval was not found")
+
+// heldItem is an item which is only added to its list once an operator approves it.
+type heldItem struct {
+	value  string
+	source string
+	reason string
+}
+
+// gate is a change held until an operator approves or rejects it. The batches waiting for approval
+// are persisted, they are processed again after a restart and their held changes are held again
+// unless the operator already decided on them.
+type gate struct {
+	approval structs.Approval
+	list     managedList
+	// approve returns the jobs making the change once it was approved.
+	approve func() []job
+}
+
+// approvals are the changes waiting for an operator's decision, by approval ID.
+var approvals = struct {
+	sync.Mutex
+	next    int
+	pending map[int]job
+}{pending: map[int]job{}}
+
+// heldJobs returns the jobs holding items of a list for approval. Dry runs record that the items
+// would be held instead.
+func heldJobs(params ListParams, held []heldItem, batchID int) []job {
+	if len(held) == 0 {
+		return nil
+	}
+	if params.dryRun {
+		values := make([]string, len(held))
+		for i, item := range held {
+			values[i] = item.value
+		}
+		return []job{keptJob(params.list, ItemAwaitingApproval, values...)}
+	}
+
+	var jobs []job
+	for _, item := range held {
+		item := item
+		jobs = append(jobs, gateJob(params.list, item.value, structs.ADD, item.reason, batchID, func() []job {
+			approved := params
+			approved.items = []string{item.value}
+			approved.removals = nil
+			jobs := []job{additionJob(approved, map[string][]string{item.source: approved.items}, batchID)}
+			if j, ok := precedenceJob(approved); ok {
+				jobs = append(jobs, j)
+			}
+			return jobs
+		}))
+	}
+	return jobs
+}
+
+// gateJob returns a job holding a change to a list until an operator approves it.
+func gateJob(list managedList, value string, operation structs.UpdateType, reason string, batchID int, approve func() []job) job {
+	return job{
+		lane:  list.key,
+		name:  list.name,
+		items: []string{value},
+		gate: &gate{
+			approval: structs.Approval{
+				BatchID:   batchID,
+				List:      list.key,
+				Name:      list.name,
+				Value:     value,
+				Operation: operation,
+				Reason:    reason,
+			},
+			list:    list,
+			approve: approve,
+		},
+	}
+}
+
+// queueApproval holds a gated job until an operator decides on its change.
+func queueApproval(j job) {
+	approvals.Lock()
+	approvals.next++
+	j.gate.approval.ID = approvals.next
+	j.gate.approval.Requested = time.Now()
+	approvals.pending[j.gate.approval.ID] = j
+	approvals.Unlock()
+
+	for _, b := range j.batches {
+		b.await()
+	}
+
+	approval := j.gate.approval
+	logrus.Warnf("%s of %s to list %s waits for approval %d: %s", approval.Operation, approval.Value, approval.Name, approval.ID, approval.Reason)
+	events.Publish(events.Event{
+		Type:    events.Approval,
+		Batches: j.batchIDs(),
+		List:    approval.Name,
+		Status:  "pending",
+		Message: approval.Reason,
+	})
+}
+
+// Approvals returns the changes waiting for an operator's decision, oldest first.
+func Approvals() []structs.Approval {
+	approvals.Lock()
+	defer approvals.Unlock()
+
+	pending := []structs.Approval{}
+	for _, j := range approvals.pending {
+		pending = append(pending, j.gate.approval)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].ID < pending[j].ID
+	})
+	return pending
+}
+
+// DecideApproval applies or drops a held change. Approved changes are queued on their list like any
+// other change, rejected changes are recorded in the batch history.
+func DecideApproval(id int, approved bool) error {
+	approvals.Lock()
+	j, ok := approvals.pending[id]
+	delete(approvals.pending, id)
+	approvals.Unlock()
+	if !ok {
+		return ErrApprovalNotFound
+	}
+
+	approval := j.gate.approval
+	status := "approved"
+	var decided []job
+	if approved {
+		decided = j.gate.approve()
+	} else {
+		status = "rejected"
+		decided = []job{keptJob(j.gate.list, ItemRejected, approval.Value)}
+	}
+	for _, b := range j.batches {
+		recordDecision(b.id, approval, approved)
+	}
+	logrus.Infof("%s of %s to list %s was %s by an operator", approval.Operation, approval.Value, approval.Name, status)
+	events.Publish(events.Event{Type: events.Approval, Batches: j.batchIDs(), List: approval.Name, Status: status})
+
+	// The batches account for the decided jobs before they are queued, so they cannot finish early.
+	for _, b := range j.batches {
+		b.decide(len(decided))
+	}
+	for _, d := range decided {
+		d.batches = j.batches
+		workers.submit(d)
+	}
+	return nil
+}
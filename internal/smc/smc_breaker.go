@@ -0,0 +1,138 @@
+package smc
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ErrCircuitOpen is returned for requests to SMC while it is considered unreachable.
+var ErrCircuitOpen = errors.New("SMC is unreachable, the circuit breaker is open")
+
+// breaker stops requests to SMC after repeated failures and probes SMC until it recovers, so work
+// is held back instead of failing request by request.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+}
+
+var smcBreaker = &breaker{}
+
+// CircuitOpen reports whether requests to SMC are currently held back.
+func CircuitOpen() bool {
+	return !smcBreaker.allow()
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.open
+}
+
+// record counts the outcome of a request. Only failures to reach SMC count towards opening the
+// breaker, error responses to valid requests do not.
+func (b *breaker) record(err error, status int) {
+	unreachable := err != nil ||
+		status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !unreachable {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.open || b.failures < viper.GetInt("breaker_failure_threshold") {
+		return
+	}
+
+	logrus.Warnf("SMC could not be reached %d times in a row, holding back work until it recovers", b.failures)
+	b.openLocked()
+}
+
+// trip opens the breaker regardless of the number of failures.
+func (b *breaker) trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		logrus.Warn("SMC could not be reached, holding back work until it recovers")
+		b.openLocked()
+	}
+}
+
+// openLocked opens the breaker and starts probing SMC. The breaker lock must be held.
+func (b *breaker) openLocked() {
+	b.open = true
+	b.openedAt = time.Now()
+	go b.probe()
+}
+
+// probe checks whether SMC can be reached again until it can, then closes the breaker and replays
+// the work held back in the meantime.
+func (b *breaker) probe() {
+	interval := time.Duration(viper.GetInt("breaker_probe_interval")) * time.Second
+	if interval < time.Second {
+		interval = time.Second
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	for {
+		time.Sleep(interval)
+
+		resp, err := client.Get(fmt.Sprintf("%s:%s/api", viper.GetString("smc_endpoint"), viper.GetString("smc_port")))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+
+		b.mu.Lock()
+		b.open = false
+		b.failures = 0
+		downtime := time.Since(b.openedAt)
+		b.mu.Unlock()
+
+		logrus.Infof("SMC is reachable again after %s", downtime.Round(time.Second))
+		recovered()
+		return
+	}
+}
+
+// recovered re-establishes the SMC session if it was lost and replays the held back work.
+func recovered() {
+	session := activeSession()
+	if session == nil || !session.LoggedIn {
+		session, _, err := NewSMCSession(
+			viper.GetString("smc_endpoint"),
+			viper.GetString("smc_port"),
+			viper.GetString("smc_api_key"))
+		if err != nil {
+			logrus.Error("error re-establishing the SMC session: ", err)
+		}
+		if session != nil {
+			setActiveSession(session)
+		}
+	}
+
+	if session := activeSession(); session != nil {
+		go reportInterrupted(session)
+	}
+	if workers != nil {
+		go workers.replay()
+	}
+}
@@ -0,0 +1,78 @@
+package smc
+
+import (
+	"main/internal/state"
+	"main/internal/structs"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// bufferSection is the state store section requests held back while SMC is unreachable are
+// persisted in.
+const bufferSection = "offline_buffer"
+
+var buffer struct {
+	sync.Mutex
+	requests []structs.Request
+}
+
+// LoadBuffer reads the requests held back before the module was restarted.
+func LoadBuffer() error {
+	buffer.Lock()
+	defer buffer.Unlock()
+
+	var loaded []structs.Request
+	if err := state.Load(bufferSection, &loaded); err != nil {
+		return err
+	}
+	buffer.requests = loaded
+	return nil
+}
+
+// Buffered returns the number of requests held back until SMC can be reached.
+func Buffered() int {
+	buffer.Lock()
+	defer buffer.Unlock()
+
+	return len(buffer.requests)
+}
+
+// Accepting reports whether new requests can be taken on, either for processing or to be held
+// back while SMC is unreachable.
+func Accepting() bool {
+	if CircuitOpen() {
+		return Buffered() < viper.GetInt("offline_buffer_capacity")
+	}
+	return Available()
+}
+
+// bufferRequest holds a request back until SMC can be reached.
+func bufferRequest(request structs.Request) {
+	buffer.Lock()
+	defer buffer.Unlock()
+
+	buffer.requests = append(buffer.requests, request)
+	saveBuffer()
+}
+
+// unbufferRequest takes the oldest request held back.
+func unbufferRequest() (structs.Request, bool) {
+	buffer.Lock()
+	defer buffer.Unlock()
+
+	if len(buffer.requests) == 0 {
+		return structs.Request{}, false
+	}
+	request := buffer.requests[0]
+	buffer.requests = buffer.requests[1:]
+	saveBuffer()
+	return request, true
+}
+
+func saveBuffer() {
+	if err := state.Save(bufferSection, buffer.requests); err != nil {
+		logrus.Error("error persisting the offline buffer: ", err)
+	}
+}
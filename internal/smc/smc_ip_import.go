@@ -0,0 +1,188 @@
+package smc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"main/internal/cache"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// importIPList replaces the contents of an IP list through the SMC import endpoint. The upload is
+// generated on the fly from the current list followed by the new items, so the list is never copied
+// in memory. The cached contents are used when available, the import is then only accepted for the
+// cached version of the list. Otherwise, or once the list changed in SMC, it is streamed from SMC.
+func (s *Session) importIPList(params ListParams, id, url string) error {
+	start := time.Now()
+	conflictRetried := false
+MethodStart:
+	var existing io.ReadCloser
+	items := params.items
+	headers := map[string]string{}
+	state, cached := cache.Get(id)
+	if cached && !conflictRetried {
+		items = newEntries(state, params.items)
+		headers["If-Match"] = state.ETag
+		if len(items) == 0 {
+			params.report.result(ItemPresent, params.items...)
+			logrus.Infof("IP list %s already contains all %d items", id, len(params.items))
+			return nil
+		}
+	} else {
+		var err error
+		state = cache.List{}
+		existing, err = s.exportIPList(url)
+		if err != nil {
+			return err
+		}
+	}
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	written := make(chan []string, 1)
+	go func() {
+		added, err := writeIPImport(form, state.Entries, existing, items)
+		written <- added
+		writer.CloseWithError(err)
+	}()
+
+	// The format selects the parser SMC reads the upload with.
+	importURL := fmt.Sprintf("%s/%s?format=txt", url, IPAddressListImportType)
+	headers["Content-Type"] = form.FormDataContentType()
+	status, resp, err := s.buildRequest(importURL, http.MethodPost, headers, body)
+	// Unblock the writer if the request ended before the upload was consumed, and wait for it to stop
+	// reading the exported list before closing it.
+	body.Close()
+	added := <-written
+	if existing != nil {
+		existing.Close()
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "There was an error creating the IP list import request")
+	}
+	defer resp.Body.Close()
+	params.report.response(s, http.MethodPost, importURL, resp)
+
+	if status == http.StatusUnauthorized {
+		s.Login()
+		goto MethodStart
+	}
+
+	// The import does not return the new version of the list, so it is fetched again before the next write.
+	if err := cache.Invalidate(id); err != nil {
+		logrus.Error("error persisting the list cache: ", err)
+	}
+
+	// The list was changed outside of the module since it was cached, it is exported again so the
+	// changes are kept.
+	if status == http.StatusPreconditionFailed && !conflictRetried {
+		logrus.Infof("list %s changed in SMC, importing it from its current contents", id)
+		params.report.retry(params.list.name, "the list changed in SMC since it was cached")
+		conflictRetried = true
+		goto MethodStart
+	}
+
+	// Handle response code.
+	if status == http.StatusNotFound {
+		return errors.Wrap(ErrListNotFound, id)
+	}
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		return errors.New(fmt.Sprintf("The IP list import request was unsuccessful. Status Code: %d", status))
+	}
+
+	params.report.result(ItemPresent, params.items...)
+	params.report.result(ItemAdded, added...)
+	logrus.Infof("IP list %s updated with %d items using the import strategy in %s", id, len(added), time.Since(start))
+	return nil
+}
+
+// exportIPList returns a stream of the current IP list contents in plain text, one entry per line.
+// A nil reader is returned when the list has no contents to export.
+func (s *Session) exportIPList(url string) (io.ReadCloser, error) {
+MethodStart:
+	status, resp, err := s.buildRequest(
+		fmt.Sprintf("%s/%s?format=txt", url, IPAddressListExportType),
+		http.MethodGet,
+		map[string]string{"Accept": "text/plain"},
+		nil)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "There was an error building the IP list export request")
+	}
+
+	switch status {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNoContent, http.StatusNotFound:
+		resp.Body.Close()
+		return nil, nil
+	case http.StatusUnauthorized:
+		resp.Body.Close()
+		s.Login()
+		goto MethodStart
+	default:
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("The IP list export request was unsuccessful. Status Code: %d", status))
+	}
+}
+
+// writeIPImport writes the multipart import form, copying the cached or exported entries line by line
+// before appending the new items. Items found among the exported entries are not appended again, the
+// items which were appended are returned.
+func writeIPImport(form *multipart.Writer, cached []string, existing io.Reader, items []string) ([]string, error) {
+	part, err := form.CreateFormFile("file", "dim_ip_list.txt")
+	if err != nil {
+		return nil, errors.Wrap(err, "error in preparing the IP list import")
+	}
+
+	pending := make(map[string]bool, len(items))
+	for _, item := range items {
+		pending[item] = true
+	}
+
+	out := bufio.NewWriter(part)
+	for _, entry := range cached {
+		out.WriteString(entry)
+		out.WriteByte('\n')
+	}
+	if existing != nil {
+		scanner := bufio.NewScanner(existing)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			delete(pending, scanner.Text())
+			out.Write(scanner.Bytes())
+			// Stop reading the export once the upload was abandoned.
+			if err := out.WriteByte('\n'); err != nil {
+				return nil, errors.Wrap(err, "error in writing the IP list import")
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, errors.Wrap(err, "error in reading the exported IP list")
+		}
+	}
+
+	var added []string
+	for _, item := range items {
+		if !pending[item] {
+			continue
+		}
+		delete(pending, item)
+		added = append(added, item)
+		out.WriteString(item)
+		out.WriteByte('\n')
+	}
+
+	if err := out.Flush(); err != nil {
+		return nil, errors.Wrap(err, "error in writing the IP list import")
+	}
+
+	return added, form.Close()
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"main/internal/cache"
+	"main/internal/provenance"
 	"main/internal/structs"
 	"main/internal/util"
 	"mime/multipart"
@@ -12,51 +14,56 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-var ErrPatchNotSupported = errors.New("patch not supported for this type")
+// ErrListNotFound is returned when the stored ID of a list no longer refers to an element in SMC.
+var ErrListNotFound = errors.New("list not found in SMC")
 
 func (s *Session) createList(params ListParams) (bool, error) {
-	var name, comment string
-	switch params.safe {
-	case true:
-		name = params.safelistName
-		comment = params.safelistComment
-	case false:
-		name = params.blocklistName
-		comment = params.blocklistComment
-	}
-	if viper.IsSet(name) {
+	if storedList(params.list.key).ID != "" {
 		return false, nil
 	}
+
+	// URL lists need to have initial values to create the list, IP Lists don't
+	var urlEntries []string
+	if params.listType == URLListType {
+		urlEntries = params.items
+	}
+
+	if _, err := s.createElement(params.list, urlEntries, params.report); err != nil {
+		return false, err
+	}
+	params.report.result(ItemAdded, urlEntries...)
+	return true, nil
+}
+
+// createElement creates a list element in SMC and stores its ID under the list's key.
+func (s *Session) createElement(list managedList, urlEntries []string, report *listReport) (string, error) {
 MethodStart:
 	// Create list
 	createList := structs.SMCList{
-		Name:     name,
-		Comment:  comment,
-		URLEntry: nil,
+		Name:     list.name,
+		Comment:  list.comment,
+		URLEntry: urlEntries,
 		IPList:   nil,
 	}
 
-	// URL lists need to have initial values to create the list, IP Lists don't
-	if params.listType == URLListType {
-		createList.URLEntry = params.items
-	}
-
 	// Convert list creation object to json.
 	b := new(bytes.Buffer)
 	json.NewEncoder(b).Encode(createList)
 
-	url := fmt.Sprintf("%s:%s/%s/%s", s.Host, s.Port, s.Version, params.listType)
+	url := fmt.Sprintf("%s:%s/%s/%s", s.Host, s.Port, s.Version, list.listType)
 	status, resp, err := s.buildRequest(url, http.MethodPost, map[string]string{"Content-Type": "application/json"}, b)
 
 	if err != nil {
-		return false, errors.Wrap(err, "There was an error creating the list creation request")
+		return "", errors.Wrap(err, "There was an error creating the list creation request")
 	}
+	report.response(s, http.MethodPost, url, resp)
 
 	if status == http.StatusUnauthorized {
 		s.Login()
@@ -65,36 +72,36 @@ MethodStart:
 
 	// Handle response code.
 	if status != http.StatusCreated {
-		return false, errors.New(fmt.Sprintf("the list creation request was unsuccessful. Status Code: %d", status))
+		return "", errors.New(fmt.Sprintf("the list creation request was unsuccessful. Status Code: %d", status))
 	}
 
 	// Store list ID
-	header := resp.Header.Get("Location")
-	idIndex := strings.LastIndex(header, "/")
-	listId := header[idIndex+1:]
-
-	viper.Set(name, listId)
-	if err := viper.WriteConfig(); err != nil {
-		logrus.Error("error writing list ID to config", err)
-	}
+	listId := elementID(resp.Header.Get("Location"))
+	storeList(list.key, listRef{ID: listId, Name: list.name, Comment: list.comment})
+	// The module adds every entry of the lists it creates.
+	provenance.Adopt(list.key)
 
 	fmt.Println("successfully created list with Status Code: ", resp.StatusCode)
-	return true, nil
+	return listId, nil
 }
 
-func (s *Session) retrieveList(params ListParams) (*http.Response, error) {
-MethodStart:
-	var id string
-	if params.safe {
-		id = viper.GetString(params.safelistName)
-	} else {
-		id = viper.GetString(params.blocklistName)
-	}
+// elementID returns the ID at the end of an SMC element href.
+func elementID(href string) string {
+	idIndex := strings.LastIndex(href, "/")
+	return href[idIndex+1:]
+}
 
-	url := fmt.Sprintf("%s:%s/%s/%s/%s", s.Host, s.Port, s.Version, params.listType, id)
+// listID returns the SMC element ID of the list targeted by params.
+func listID(params ListParams) string {
+	return storedList(params.list.key).ID
+}
+
+func (s *Session) retrieveList(listType ListType, id string) (*http.Response, error) {
+MethodStart:
+	url := fmt.Sprintf("%s:%s/%s/%s/%s", s.Host, s.Port, s.Version, listType, id)
 
 	// We need to append an extra identifier for IP list types
-	if params.listType == IPListType {
+	if listType == IPListType {
 		url = fmt.Sprintf("%s/%s", url, IPAddressListType)
 	}
 
@@ -114,6 +121,10 @@ MethodStart:
 	}
 
 	// Handle response code.
+	if status == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errors.Wrap(ErrListNotFound, id)
+	}
 	if status != http.StatusOK {
 		return nil, errors.New(fmt.Sprintf("The list retrieval request was unsuccessful. Status Code: %d", status))
 	}
@@ -121,18 +132,99 @@ MethodStart:
 	return resp, nil
 }
 
-func (s *Session) updateList(params ListParams) error {
-	if params.UpdateType == structs.DELETE && params.listType == IPListType {
-		return ErrPatchNotSupported
+// listState returns the cached contents of the targeted list, fetching them from SMC when the cache
+// is cold or stale.
+func (s *Session) listState(params ListParams) (cache.List, error) {
+	id := listID(params)
+	if list, ok := cache.Get(id); ok {
+		return list, nil
+	}
+	return s.refreshList(params.listType, id)
+}
+
+// adopt records the entries of the targeted list which predate provenance tracking, once per list,
+// if enabled by adopt_existing_entries. Otherwise such entries are foreign to the module. The list is
+// fetched from SMC when its entries are not cached.
+func (s *Session) adopt(params ListParams) error {
+	if !viper.GetBool("adopt_existing_entries") || provenance.Adopted(params.list.key) || storedList(params.list.key).ID == "" {
+		return nil
 	}
-	var id string
-	if params.safe {
-		id = viper.GetString(params.safelistName)
-	} else {
-		id = viper.GetString(params.blocklistName)
+	state, err := s.listState(params)
+	if errors.Cause(err) == ErrListNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "error reading list "+params.list.name+" to record its existing entries")
+	}
+	provenance.Adopt(params.list.key, state.Entries...)
+	return nil
+}
+
+// refreshList downloads a list from SMC and replaces its cached state.
+func (s *Session) refreshList(listType ListType, id string) (cache.List, error) {
+	resp, err := s.retrieveList(listType, id)
+	if err != nil {
+		return cache.List{}, err
+	}
+	defer resp.Body.Close()
+
+	list := structs.SMCList{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return cache.List{}, errors.Wrap(err, "There was an error decoding the retrieved list")
+	}
+
+	state := cache.List{
+		Type:    listType,
+		ETag:    resp.Header.Get("ETag"),
+		Entries: list.URLEntry,
+	}
+	if listType == IPListType {
+		state.Entries = list.IPList
+	}
+
+	if err := cache.Set(id, state); err != nil {
+		logrus.Error("error persisting the list cache: ", err)
+	}
+
+	return state, nil
+}
+
+// newEntries returns the items which are not yet present in the cached list.
+func newEntries(state cache.List, items []string) []string {
+	index := state.Index()
+	var added []string
+	for _, item := range items {
+		if _, ok := index[item]; ok {
+			continue
+		}
+		index[item] = struct{}{}
+		added = append(added, item)
+	}
+	return added
+}
+
+func (s *Session) updateList(params ListParams) error {
+	id := listID(params)
+	if id == "" {
+		return errors.Wrap(ErrListNotFound, params.list.name)
 	}
 
 	url := fmt.Sprintf("%s:%s/%s/%s/%s", s.Host, s.Port, s.Version, params.listType, id)
+	start := time.Now()
+
+	// Large batches are uploaded through the IP list import endpoint rather than
+	// round tripping the whole list as JSON.
+	if params.listType == IPListType && len(params.removals) == 0 && len(params.items) >= viper.GetInt("ip_import_threshold") {
+		return s.importIPList(params, id, url)
+	}
+
+	// A conflicting write is retried once against freshly retrieved list contents.
+	conflictRetried := false
+MethodStart:
+	state, err := s.listState(params)
+	if err != nil {
+		return err
+	}
 
 	// Use an interface here for updateObject as the types for IP List and URL List are different
 	var updateObject interface{}
@@ -140,8 +232,14 @@ func (s *Session) updateList(params ListParams) error {
 	var updateMethod string
 	// 202 Accepted for IP list, 200 OK for URL list
 	var successfulUpdateStatusCode int
+	// Expected IP list contents once the update has been applied
+	var entries []string
+	// Items which are actually added to or removed from the list
+	var added, removed []string
+	updateURL := url
 
-	var headers = map[string]string{"Content-Type": "application/json"}
+	// Writes are computed against the cached contents, so they are only valid for that version of the list.
+	var headers = map[string]string{"Content-Type": "application/json", "If-Match": state.ETag}
 
 	// We need to switch on the list type as the update methods are different for each
 	switch params.listType {
@@ -149,62 +247,70 @@ func (s *Session) updateList(params ListParams) error {
 		updateMethod = http.MethodPost
 		successfulUpdateStatusCode = http.StatusAccepted
 
-		resp, err := s.retrieveList(params)
-		if err != nil {
-			logrus.Error(err)
-			go s.updateBatchStatus(params.batchID, structs.Failed)
+		// IP lists are replaced as a whole, so removals are left out of the cached contents.
+		removals := map[string]struct{}{}
+		for _, val := range params.removals {
+			removals[val] = struct{}{}
+		}
+		params.report.result(ItemAbsent, params.removals...)
+		entries = make([]string, 0, len(state.Entries)+len(params.items))
+		for _, val := range state.Entries {
+			if _, ok := removals[val]; ok {
+				removed = append(removed, val)
+				continue
+			}
+			entries = append(entries, val)
+		}
+
+		added = newEntries(state, params.items)
+		params.report.result(ItemPresent, params.items...)
+		if len(added) == 0 && len(removed) == 0 {
+			logrus.Infof("IP list %s does not require any changes", id)
+			return nil
 		}
-		// Set ETAG
-		headers["If-Match"] = resp.Header.Get("ETag")
-		list := structs.SMCList{}
-		json.NewDecoder(resp.Body).Decode(&list)
 
 		// We need to append an extra identifier for IP list types
-		url = fmt.Sprintf("%s/%s", url, IPAddressListType)
+		updateURL = fmt.Sprintf("%s/%s", url, IPAddressListType)
 
 		// Append the items to the IP list
-		list.IPList = append(list.IPList, params.items...)
-		updateObject = list
+		entries = append(entries, added...)
+		updateObject = structs.SMCList{IPList: entries}
 	case URLListType:
 		// The URL list update is a PATCH instead of a POST like the IP List
 		updateMethod = http.MethodPatch
 		successfulUpdateStatusCode = http.StatusOK
 
 		headers["Accept"] = "application/json-patch+json"
-		headers["If-Match"] = "*"
 		var patchList []structs.SMCPatch
-		if params.UpdateType == structs.DELETE {
-			resp, err := s.retrieveList(params)
-			if err != nil {
-				logrus.Error(err)
-				go s.updateBatchStatus(params.batchID, structs.Failed)
-			}
-			list := structs.SMCList{}
-			json.NewDecoder(resp.Body).Decode(&list)
-
-			var indexes []int
-
-			for i, val := range list.URLEntry {
-				if val == params.item {
-					indexes = append(indexes, i+1)
-				}
-			}
-
-			for _, val := range indexes {
+		// Remove from the end of the list first so earlier indexes stay valid.
+		removals := map[string]struct{}{}
+		for _, val := range params.removals {
+			removals[val] = struct{}{}
+		}
+		params.report.result(ItemAbsent, params.removals...)
+		for i := len(state.Entries) - 1; i >= 0; i-- {
+			if _, ok := removals[state.Entries[i]]; ok {
+				removed = append(removed, state.Entries[i])
 				patchList = append(patchList, structs.SMCPatch{
 					Op:    structs.DELETE,
-					Path:  fmt.Sprintf("/url_entry/%d", val),
+					Path:  fmt.Sprintf("/url_entry/%d", i+1),
 					Value: "",
 				})
 			}
-		} else if params.UpdateType == structs.ADD {
-			for _, val := range params.items {
-				patchList = append(patchList, structs.SMCPatch{
-					Op:    structs.ADD,
-					Path:  "/url_entry/1",
-					Value: val,
-				})
-			}
+		}
+		added = newEntries(state, params.items)
+		params.report.result(ItemPresent, params.items...)
+		for _, val := range added {
+			patchList = append(patchList, structs.SMCPatch{
+				Op:    structs.ADD,
+				Path:  "/url_entry/1",
+				Value: val,
+			})
+		}
+
+		if len(patchList) == 0 {
+			logrus.Infof("URL list %s does not require any changes", id)
+			return nil
 		}
 
 		updateObject = patchList
@@ -212,14 +318,14 @@ func (s *Session) updateList(params ListParams) error {
 
 	// Convert list update object to json.
 	b := new(bytes.Buffer)
-	err := json.NewEncoder(b).Encode(updateObject)
+	err = json.NewEncoder(b).Encode(updateObject)
 
 	if err != nil {
 		return errors.Wrap(err, "There was an error marshalling the list update payload")
 	}
 
-	_, resp, err := s.buildRequest(
-		url,
+	status, resp, err := s.buildRequest(
+		updateURL,
 		updateMethod,
 		headers,
 		b)
@@ -228,18 +334,72 @@ func (s *Session) updateList(params ListParams) error {
 	if err != nil {
 		return errors.Wrap(err, "There was an error creating the list update request")
 	}
+	defer resp.Body.Close()
+	params.report.response(s, updateMethod, updateURL, resp)
+
+	if status == http.StatusUnauthorized {
+		s.Login()
+		goto MethodStart
+	}
+
+	// The list was changed outside of the module since it was cached.
+	if status == http.StatusPreconditionFailed && !conflictRetried {
+		logrus.Infof("list %s changed in SMC, refreshing the cache and retrying", id)
+		params.report.retry(params.list.name, "the list changed in SMC since it was cached")
+		conflictRetried = true
+		if err := cache.Invalidate(id); err != nil {
+			logrus.Error("error persisting the list cache: ", err)
+		}
+		goto MethodStart
+	}
 
 	// Handle response code.
-	if resp.StatusCode != successfulUpdateStatusCode {
-		return errors.New(fmt.Sprintf("The list update request was unsuccessful. Status Code: %d", resp.StatusCode))
+	if status == http.StatusNotFound {
+		return errors.Wrap(ErrListNotFound, id)
+	}
+	if status != successfulUpdateStatusCode {
+		if err := cache.Invalidate(id); err != nil {
+			logrus.Error("error persisting the list cache: ", err)
+		}
+		return errors.New(fmt.Sprintf("The list update request was unsuccessful. Status Code: %d", status))
+	}
+
+	// Record the new list contents. URL lists are returned in the response, as entries are
+	// inserted at SMC's discretion.
+	if params.listType == URLListType {
+		list := structs.SMCList{}
+		if err := json.NewDecoder(resp.Body).Decode(&list); err == nil {
+			entries = list.URLEntry
+		} else {
+			resp.Header.Del("ETag")
+		}
+	}
+	err = cache.Set(id, cache.List{
+		Type:    params.listType,
+		ETag:    resp.Header.Get("ETag"),
+		Entries: entries,
+	})
+	if err != nil {
+		logrus.Error("error persisting the list cache: ", err)
+	}
+
+	params.report.result(ItemAdded, added...)
+	params.report.result(ItemRemoved, removed...)
+
+	if params.listType == IPListType {
+		logrus.Infof("IP list %s updated with %d items using the json strategy in %s", id, len(params.items), time.Since(start))
 	}
 
 	return nil
 }
 
 func (s *Session) RetrieveGlobalSnortConfig() (string, error) {
+	return s.exportSnortConfig(snortTempDirPath)
+}
 
-	snortTempDirPath := filepath.Join("/temp", "snort")
+// exportSnortConfig exports the global snort configuration into a temp dir, returning the dir the
+// configuration was extracted to. The exported zip is kept next to it.
+func (s *Session) exportSnortConfig(snortTempDirPath string) (string, error) {
 
 	// exists
 	if _, err := os.Stat(snortTempDirPath); !os.IsNotExist(err) {
@@ -278,7 +438,7 @@ func (s *Session) RetrieveGlobalSnortConfig() (string, error) {
 	if resp.StatusCode == http.StatusOK {
 
 		// Create the file
-		globalSnortsZipPath := filepath.Join(snortTempDirPath, "global-smc-snorts.zip")
+		globalSnortsZipPath := filepath.Join(snortTempDirPath, exportedSnortZip)
 		out, err := os.Create(globalSnortsZipPath)
 		if err != nil {
 			return "", errors.Wrap(err, "creating global-smc-snorts.zip")
@@ -297,7 +457,7 @@ func (s *Session) RetrieveGlobalSnortConfig() (string, error) {
 		}
 
 	} else if resp.StatusCode != http.StatusNotFound {
-		return "", errors.New(fmt.Sprintf("error exporting global snort configurations, status code is: %s", resp.StatusCode))
+		return "", errors.New(fmt.Sprintf("error exporting global snort configurations, status code is: %d", resp.StatusCode))
 	}
 
 	err = util.CreateFileIfNotExist(filepath.Join(snortExportTempDirPath, "rules_include.config"))
@@ -348,14 +508,41 @@ func (s *Session) getSnortUrl() (string, error) {
 	return snortUrl, nil
 }
 
+// ImportGlobalSnortConfig uploads the configuration in exportDirPath. The configuration it replaces is
+// backed up first, and restored if the import cannot be confirmed by exporting it again.
 func (s *Session) ImportGlobalSnortConfig(exportDirPath string) error {
 
-	zipDestPath := filepath.Join("/temp", "snort")
-	zipDirPath, err := util.Zip(exportDirPath, zipDestPath, "global-snorts")
+	backup, err := backupSnortConfig()
+	if err != nil {
+		return errors.Wrap(err, "error backing up the global snort configuration")
+	}
+
+	zipDirPath, err := util.Zip(exportDirPath, snortTempDirPath, "global-snorts")
 	if err != nil {
 		return errors.Wrap(err, "zipping global-snorts.zip")
 	}
 
+	err = s.importSnortZip(zipDirPath)
+	if err == nil {
+		err = s.verifySnortConfig(exportDirPath)
+	}
+	if err == nil || errors.Cause(err) == ErrCircuitOpen {
+		return err
+	}
+
+	if backup == "" {
+		return errors.Wrap(err, "no backup of the previous global snort configuration is available to restore")
+	}
+	logrus.Error("restoring the previous global snort configuration: ", err)
+	if restoreErr := s.importSnortZip(backup); restoreErr != nil {
+		return errors.Wrap(restoreErr, fmt.Sprintf("error restoring the global snort configuration from %s after: %s", filepath.Base(backup), err))
+	}
+	logrus.Warnf("the global snort configuration was restored from %s", filepath.Base(backup))
+	return errors.Wrap(err, "the global snort configuration was restored from backup")
+}
+
+// importSnortZip uploads a zipped global snort configuration.
+func (s *Session) importSnortZip(zipDirPath string) error {
 	file, err := os.Open(zipDirPath)
 	if err != nil {
 		return errors.Wrap(err, "error in oppening global-snorts.zip for upload")
@@ -385,7 +572,7 @@ func (s *Session) ImportGlobalSnortConfig(exportDirPath string) error {
 	}
 
 	if resp.StatusCode != http.StatusCreated {
-		return errors.New(fmt.Sprintf("error importing snort global configurations, status code is: %s", resp.StatusCode))
+		return errors.New(fmt.Sprintf("error importing snort global configurations, status code is: %d", resp.StatusCode))
 	}
 
 	return nil
@@ -0,0 +1,111 @@
+package smc
+
+import (
+	"encoding/json"
+	"main/internal/state"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// listIDsSection is the state store section the list element IDs are persisted in.
+const listIDsSection = "list_ids"
+
+// listRef is the stored identity of a list element in SMC.
+type listRef struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Comment string `json:"comment"`
+}
+
+var (
+	listIDsMu sync.Mutex
+	listIDs   = map[string]listRef{}
+)
+
+// LoadListIDs reads the stored list element IDs. IDs written into config.yml by earlier versions of
+// the module are moved into the state store.
+func LoadListIDs() error {
+	listIDsMu.Lock()
+	defer listIDsMu.Unlock()
+
+	// Version 1 stored only the element ID under the fixed list name.
+	state.RegisterMigration(listIDsSection, 1, func(data json.RawMessage) (json.RawMessage, error) {
+		ids := map[string]string{}
+		if err := json.Unmarshal(data, &ids); err != nil {
+			return nil, err
+		}
+		refs := map[string]listRef{}
+		for name, id := range ids {
+			if kind, ok := legacyListNames[name]; ok {
+				refs[kind] = legacyRef(name, kind, id)
+			}
+		}
+		return json.Marshal(refs)
+	})
+
+	loaded := map[string]listRef{}
+	if err := state.Load(listIDsSection, &loaded); err != nil {
+		return err
+	}
+
+	migrated := false
+	for name, kind := range legacyListNames {
+		if _, ok := loaded[kind]; ok {
+			continue
+		}
+		if id := viper.GetString(name); id != "" {
+			loaded[kind] = legacyRef(name, kind, id)
+			migrated = true
+		}
+	}
+
+	listIDs = loaded
+	if migrated {
+		return state.Save(listIDsSection, listIDs)
+	}
+	return nil
+}
+
+// legacyRef returns the reference of a list created with a fixed name and comment.
+func legacyRef(name string, kind ListKind, id string) listRef {
+	comment := strings.Replace(DefaultListCommentTemplate, "{description}", listKinds[kind].description, 1)
+	return listRef{ID: id, Name: name, Comment: comment}
+}
+
+// storedList returns the stored reference of a list. The ID is empty if the list has not been created.
+func storedList(key string) listRef {
+	listIDsMu.Lock()
+	defer listIDsMu.Unlock()
+
+	return listIDs[key]
+}
+
+// storedLists returns the stored references of every list, keyed by list key.
+func storedLists() map[string]listRef {
+	listIDsMu.Lock()
+	defer listIDsMu.Unlock()
+
+	refs := make(map[string]listRef, len(listIDs))
+	for key, ref := range listIDs {
+		refs[key] = ref
+	}
+	return refs
+}
+
+// storeList records the SMC element of a list. An empty ID forgets the list.
+func storeList(key string, ref listRef) {
+	listIDsMu.Lock()
+	defer listIDsMu.Unlock()
+
+	if ref.ID == "" {
+		delete(listIDs, key)
+	} else {
+		listIDs[key] = ref
+	}
+	if err := state.Save(listIDsSection, listIDs); err != nil {
+		logrus.Error("error writing list ID to the state store: ", err)
+	}
+}
@@ -0,0 +1,113 @@
+package smc
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// listKind describes one kind of list maintained by the module.
+type listKind struct {
+	listType    ListType
+	description string
+}
+
+var listKinds = map[ListKind]listKind{
+	IPBlocklist:  {IPListType, "IP Blocklist"},
+	IPSafelist:   {IPListType, "IP Safelist"},
+	URLBlocklist: {URLListType, "URL/Domain Blocklist"},
+	URLSafelist:  {URLListType, "URL/Domain Safelist"},
+}
+
+// legacyListNames maps the fixed list names used before naming templates to their kinds.
+var legacyListNames = map[string]ListKind{
+	"dim_blocklist":     IPBlocklist,
+	"dim_safelist":      IPSafelist,
+	"dim_url_blocklist": URLBlocklist,
+	"dim_url_safelist":  URLSafelist,
+}
+
+// managedList is a single SMC list maintained by the module.
+type managedList struct {
+	// key identifies the list in the state store independently of its name.
+	key      string
+	kind     ListKind
+	source   string
+	listType ListType
+	name     string
+	comment  string
+}
+
+// kindFor returns the kind of list an item of the given list type is written to.
+func kindFor(listType ListType, safe bool) ListKind {
+	switch {
+	case listType == IPListType && safe:
+		return IPSafelist
+	case listType == IPListType:
+		return IPBlocklist
+	case safe:
+		return URLSafelist
+	default:
+		return URLBlocklist
+	}
+}
+
+// resolveList renders the name and comment of the list of the given kind for a source. The source is
+// only part of the list's identity when the configured templates reference it.
+func resolveList(kind ListKind, source string) managedList {
+	nameTemplate := viper.GetString("list_name_template")
+	commentTemplate := viper.GetString("list_comment_template")
+
+	list := managedList{key: kind, kind: kind, listType: listKinds[kind].listType}
+	if strings.Contains(nameTemplate, "{source}") || strings.Contains(commentTemplate, "{source}") {
+		list.source = sanitizeSource(source)
+		list.key = kind + "/" + list.source
+	}
+
+	replacer := strings.NewReplacer(
+		"{prefix}", viper.GetString("list_name_prefix"),
+		"{type}", kind,
+		"{source}", list.source,
+		"{description}", listKinds[kind].description)
+	list.name = replacer.Replace(nameTemplate)
+	list.comment = replacer.Replace(commentTemplate)
+
+	return list
+}
+
+// resolveKey renders the list stored under a state store key. The list is not current when the kind is
+// unknown or the templates no longer identify lists the same way, e.g. after {source} was removed.
+func resolveKey(key string) (managedList, bool) {
+	parts := strings.SplitN(key, "/", 2)
+	if _, ok := listKinds[parts[0]]; !ok {
+		return managedList{}, false
+	}
+	source := ""
+	if len(parts) == 2 {
+		source = parts[1]
+	}
+	list := resolveList(parts[0], source)
+	return list, list.key == key
+}
+
+// elementSource returns the source an item is attributed to for list naming.
+func elementSource(source, serviceName string) string {
+	if source != "" {
+		return source
+	}
+	if serviceName != "" {
+		return serviceName
+	}
+	return "dim"
+}
+
+// sanitizeSource reduces a source to characters which are safe in SMC element names and state keys.
+func sanitizeSource(source string) string {
+	source = strings.ToLower(strings.TrimSpace(source))
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '_'
+	}, source)
+}
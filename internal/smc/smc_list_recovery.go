@@ -0,0 +1,250 @@
+package smc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"main/internal/cache"
+	"main/internal/structs"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// VerifyLists checks that every stored list ID still refers to an element in SMC and recovers the
+// lists which do not. Lists whose configured name or comment changed are renamed.
+func (s *Session) VerifyLists() {
+	for key := range storedLists() {
+		s.verifyList(key)
+	}
+}
+
+// verifyList checks and recovers or renames the list stored under a key. Lists stored under a key
+// which is no longer current are only kept track of while they exist.
+func (s *Session) verifyList(key string) {
+	ref := storedList(key)
+	list, current := resolveKey(key)
+	if list.kind == "" || ref.ID == "" {
+		return
+	}
+
+	exists, err := s.elementExists(list.listType, ref.ID)
+	if err != nil {
+		logrus.Error("error verifying list ", ref.Name, ": ", err)
+		return
+	}
+
+	if !exists {
+		// A list stored under a key the templates no longer produce is not written to anymore, it is
+		// forgotten instead of being recreated.
+		if !current {
+			if err := cache.Remove(ref.ID); err != nil {
+				logrus.Error("error persisting the list cache: ", err)
+			}
+			storeList(key, listRef{})
+			logrus.Warnf("list %s was not found in SMC and is no longer tracked, as the list naming changed", ref.Name)
+			return
+		}
+		if _, err := s.recoverList(list); err != nil {
+			logrus.Error("error recovering list ", ref.Name, ": ", err)
+		}
+		return
+	}
+
+	if current && (ref.Name != list.name || ref.Comment != list.comment) {
+		if err := s.renameList(list, ref); err != nil {
+			logrus.Error("error renaming list ", ref.Name, " to ", list.name, ": ", err)
+		}
+	}
+}
+
+// recoverList replaces the stored ID of a list which no longer exists in SMC. An element with the
+// list's name is re-adopted if there is one, otherwise the list is recreated. URL lists cannot be
+// created empty, so their ID is forgotten and the list is created by the next addition.
+func (s *Session) recoverList(list managedList) (string, error) {
+	previous := storedList(list.key).ID
+	if previous != "" {
+		if err := cache.Remove(previous); err != nil {
+			logrus.Error("error persisting the list cache: ", err)
+		}
+	}
+
+	href, err := s.findElement(list.listType, list.name)
+	if err != nil {
+		return "", err
+	}
+
+	if href != "" {
+		id := elementID(href)
+		storeList(list.key, listRef{ID: id, Name: list.name, Comment: list.comment})
+		logrus.Warnf("list %s was not found with ID %s and has been re-adopted with ID %s", list.name, previous, id)
+		return id, nil
+	}
+
+	if list.listType == URLListType {
+		storeList(list.key, listRef{})
+		logrus.Warnf("list %s was not found in SMC and will be recreated on the next addition", list.name)
+		return "", nil
+	}
+
+	id, err := s.createElement(list, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	logrus.Warnf("list %s was not found in SMC and has been recreated with ID %s", list.name, id)
+	return id, nil
+}
+
+// renameList applies the configured name and comment to an existing list element. The list is left
+// alone if another element already uses the new name.
+func (s *Session) renameList(list managedList, ref listRef) error {
+	href, err := s.findElement(list.listType, list.name)
+	if err != nil {
+		return err
+	}
+	if href != "" && elementID(href) != ref.ID {
+		return errors.New(fmt.Sprintf("another element is already named %s", list.name))
+	}
+
+	elementURL := fmt.Sprintf("%s:%s/%s/%s/%s", s.Host, s.Port, s.Version, list.listType, ref.ID)
+	conflictRetried := false
+MethodStart:
+	status, resp, err := s.buildRequest(elementURL, http.MethodGet, map[string]string{"Accept": "application/json"}, nil)
+	if err != nil {
+		return errors.Wrap(err, "There was an error building the element retrieval request")
+	}
+	if status == http.StatusUnauthorized {
+		resp.Body.Close()
+		s.Login()
+		goto MethodStart
+	}
+	if status != http.StatusOK {
+		resp.Body.Close()
+		return errors.New(fmt.Sprintf("The element retrieval request was unsuccessful. Status Code: %d", status))
+	}
+
+	// The element is updated as a whole, so unknown attributes are kept as they were.
+	element := map[string]interface{}{}
+	err = json.NewDecoder(resp.Body).Decode(&element)
+	resp.Body.Close()
+	if err != nil {
+		return errors.Wrap(err, "There was an error decoding the element")
+	}
+	element["name"] = list.name
+	element["comment"] = list.comment
+
+	b := new(bytes.Buffer)
+	if err := json.NewEncoder(b).Encode(element); err != nil {
+		return errors.Wrap(err, "There was an error marshalling the element update")
+	}
+
+	status, resp, err = s.buildRequest(
+		elementURL,
+		http.MethodPut,
+		map[string]string{"Content-Type": "application/json", "If-Match": resp.Header.Get("ETag")},
+		b)
+	if err != nil {
+		return errors.Wrap(err, "There was an error building the element update request")
+	}
+	resp.Body.Close()
+
+	switch {
+	case status == http.StatusOK:
+	case status == http.StatusUnauthorized:
+		s.Login()
+		goto MethodStart
+	case status == http.StatusPreconditionFailed && !conflictRetried:
+		// The element changed since it was retrieved, so it is retrieved again.
+		conflictRetried = true
+		goto MethodStart
+	default:
+		return errors.New(fmt.Sprintf("The element update request was unsuccessful. Status Code: %d", status))
+	}
+
+	storeList(list.key, listRef{ID: ref.ID, Name: list.name, Comment: list.comment})
+	logrus.Infof("list %s has been renamed to %s", ref.Name, list.name)
+	return nil
+}
+
+// retryRecovered recovers the list targeted by params after it was not found in SMC and applies the
+// update again.
+func (s *Session) retryRecovered(params ListParams) error {
+	id, err := s.recoverList(params.list)
+	if err != nil {
+		return err
+	}
+
+	params.report.retry(params.list.name, "the list was not found in SMC and has been recovered")
+
+	if id == "" {
+		// There is nothing to remove from a list which no longer exists.
+		if len(params.items) == 0 {
+			return nil
+		}
+		if _, err := s.createList(params); err != nil {
+			return err
+		}
+		if params.listType == URLListType {
+			return nil
+		}
+	}
+
+	return s.updateList(params)
+}
+
+// elementExists reports whether an element with the given ID exists in SMC.
+func (s *Session) elementExists(listType ListType, id string) (bool, error) {
+MethodStart:
+	elementURL := fmt.Sprintf("%s:%s/%s/%s/%s", s.Host, s.Port, s.Version, listType, id)
+	status, resp, err := s.buildRequest(elementURL, http.MethodGet, map[string]string{"Accept": "application/json"}, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "There was an error building the element retrieval request")
+	}
+	resp.Body.Close()
+
+	switch status {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	case http.StatusUnauthorized:
+		s.Login()
+		goto MethodStart
+	default:
+		return false, errors.New(fmt.Sprintf("The element retrieval request was unsuccessful. Status Code: %d", status))
+	}
+}
+
+// findElement searches SMC for an element of the given type with exactly the given name and returns
+// its href, or an empty string if there is none.
+func (s *Session) findElement(listType ListType, name string) (string, error) {
+MethodStart:
+	searchURL := fmt.Sprintf("%s:%s/%s/%s?filter=%s&exact_match=true", s.Host, s.Port, s.Version, listType, url.QueryEscape(name))
+	status, resp, err := s.buildRequest(searchURL, http.MethodGet, map[string]string{"Accept": "application/json"}, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "There was an error building the element search request")
+	}
+	defer resp.Body.Close()
+
+	if status == http.StatusUnauthorized {
+		s.Login()
+		goto MethodStart
+	}
+	if status != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("The element search request was unsuccessful. Status Code: %d", status))
+	}
+
+	results := structs.SMCElementResults{}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return "", errors.Wrap(err, "There was an error decoding the element search results")
+	}
+
+	for _, result := range results.Results {
+		if result.Name == name {
+			return result.Href, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,167 @@
+package smc
+
+import (
+	"context"
+	"main/internal/batches"
+	"main/internal/cache"
+	"main/internal/structs"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// planList records the changes params would make to the targeted list without writing to SMC. The
+// list is compared against its cached contents, which are fetched from SMC when the cache is cold.
+func (s *Session) planList(params ListParams) error {
+	state := cache.List{}
+	if storedList(params.list.key).ID == "" {
+		params.report.create()
+	} else {
+		current, err := s.listState(params)
+		switch {
+		case errors.Cause(err) == ErrListNotFound:
+			params.report.create()
+		case err != nil:
+			return err
+		default:
+			state = current
+		}
+	}
+
+	index := state.Index()
+	added := newEntries(state, params.items)
+	params.report.result(ItemPresent, params.items...)
+	params.report.result(ItemWouldAdd, added...)
+	for _, item := range added {
+		index[item] = struct{}{}
+	}
+	for _, item := range params.removals {
+		if _, ok := index[item]; ok {
+			params.report.result(ItemWouldRemove, item)
+		} else {
+			params.report.result(ItemAbsent, item)
+		}
+	}
+	return nil
+}
+
+// planned is the result of a job of a plan.
+type planned struct {
+	lane   string
+	result batches.ListResult
+	files  []string
+}
+
+// planCollector gathers the results of the jobs of a plan as they finish on their lanes.
+type planCollector struct {
+	mu      sync.Mutex
+	results []planned
+	// finished is closed once every job of the plan finished.
+	finished chan struct{}
+}
+
+func (c *planCollector) add(lane string, result batches.ListResult, files []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results = append(c.results, planned{lane: lane, result: result, files: files})
+}
+
+// Plan returns the SMC operations a request would result in. The request is planned by the same
+// jobs which process it, as a dry run on the lanes of the lists it targets, so it sees the lists
+// as they are between the changes queued before it. Plan returns early with the error of ctx when
+// it is done before the plan.
+func Plan(ctx context.Context, request structs.Request) (structs.Plan, error) {
+	request.DryRun = true
+	// The plan is not recorded in the batch history.
+	request.BatchID = 0
+
+	plan := structs.Plan{Lists: []structs.PlannedList{}}
+	if workers == nil {
+		return plan, ErrNoSession
+	}
+	jobs := requestJobs(request)
+	if len(jobs) == 0 {
+		return plan, nil
+	}
+
+	// Results are reported in the order of the jobs, not in the order they finished.
+	order := map[string]int{}
+	collector := &planCollector{finished: make(chan struct{})}
+	b := &batch{request: request, pending: len(jobs), dryRun: true, plan: collector}
+	for i, j := range jobs {
+		if _, ok := order[j.lane]; !ok {
+			order[j.lane] = i
+		}
+		j.batches = []*batch{b}
+		workers.submit(j)
+	}
+
+	select {
+	case <-collector.finished:
+	case <-ctx.Done():
+		return plan, ctx.Err()
+	}
+	sort.SliceStable(collector.results, func(i, k int) bool {
+		return order[collector.results[i].lane] < order[collector.results[k].lane]
+	})
+
+	// Policies are refreshed once a batch succeeded for any of its lists, as in processing.
+	succeeded := false
+	for _, p := range collector.results {
+		result := p.result
+		if result.Error == "" {
+			succeeded = true
+		}
+		if p.lane == snortLane {
+			for _, item := range result.Items {
+				switch item.Result {
+				case ItemWouldAdd:
+					plan.SnortRules = append(plan.SnortRules, item.Value)
+				case ItemWouldRemove:
+					plan.SnortRemovals = append(plan.SnortRemovals, item.Value)
+				case ItemInvalid:
+					plan.SnortRejected = append(plan.SnortRejected, item.Value)
+				}
+			}
+			plan.SnortFiles = append(plan.SnortFiles, p.files...)
+			continue
+		}
+		// Values left as they are in a list are recorded by a job of their own, which is merged
+		// with the changes to the list.
+		index := len(plan.Lists)
+		for i := range plan.Lists {
+			if plan.Lists[i].List == result.List {
+				index = i
+			}
+		}
+		if index == len(plan.Lists) {
+			plan.Lists = append(plan.Lists, structs.PlannedList{List: result.List})
+		}
+		list := &plan.Lists[index]
+		list.Create = list.Create || result.CreateList
+		list.Conflicts = append(list.Conflicts, result.Conflicts...)
+		if result.Error != "" {
+			list.Error = result.Error
+		}
+		for _, item := range result.Items {
+			switch item.Result {
+			case ItemWouldAdd:
+				list.Add = append(list.Add, item.Value)
+			case ItemWouldRemove:
+				list.Remove = append(list.Remove, item.Value)
+			case ItemPresent, ItemAbsent, ItemReferenced, ItemForeign, ItemConflict:
+				list.Unchanged = append(list.Unchanged, item.Value)
+			case ItemProtected:
+				list.Rejected = append(list.Rejected, item.Value)
+			case ItemAwaitingApproval:
+				list.Held = append(list.Held, item.Value)
+			}
+		}
+	}
+	if succeeded {
+		plan.Policies = refreshedPolicies()
+	}
+	return plan, nil
+}
@@ -0,0 +1,106 @@
+package smc
+
+import (
+	"fmt"
+	"main/internal/events"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// policyLane is the lane policy refreshes are serialized on.
+const policyLane = "policies"
+
+// FirewallPolicyType is the element type of the policies refreshed after their lists changed.
+const FirewallPolicyType = "elements/fw_policy"
+
+// pendingRefresh collects the batches finished while a policy refresh is queued, so they are
+// covered by a single refresh.
+var pendingRefresh struct {
+	sync.Mutex
+	batches []int
+	queued  bool
+}
+
+// refreshPolicies queues a refresh of the configured policies after a batch changed their lists.
+func refreshPolicies(batchID int) {
+	if len(refreshedPolicies()) == 0 || workers == nil {
+		return
+	}
+
+	pendingRefresh.Lock()
+	defer pendingRefresh.Unlock()
+
+	if batchID != 0 {
+		pendingRefresh.batches = append(pendingRefresh.batches, batchID)
+	}
+	if pendingRefresh.queued {
+		return
+	}
+	pendingRefresh.queued = true
+
+	workers.submit(job{
+		lane: policyLane,
+		name: policyLane,
+		run: func(session *Session, report *listReport) error {
+			pendingRefresh.Lock()
+			batchIDs := pendingRefresh.batches
+			pendingRefresh.batches = nil
+			pendingRefresh.queued = false
+			pendingRefresh.Unlock()
+
+			session.refreshPolicies(batchIDs)
+			return nil
+		},
+	})
+}
+
+// refreshedPolicies returns the names of the policies refreshed after a batch succeeded.
+func refreshedPolicies() []string {
+	return viper.GetStringSlice("refresh_policies")
+}
+
+// refreshPolicies refreshes every configured policy on the engines it is installed on.
+func (s *Session) refreshPolicies(batchIDs []int) {
+	for _, name := range refreshedPolicies() {
+		event := events.Event{Type: events.PolicyRefresh, Batches: batchIDs, List: name, Status: "success"}
+		if err := s.refreshPolicy(name); err != nil {
+			logrus.Error("error refreshing policy ", name, ": ", err)
+			event.Status = "failed"
+			event.Message = err.Error()
+		} else {
+			logrus.Infof("refreshed policy %s", name)
+		}
+		events.Publish(event)
+	}
+}
+
+// refreshPolicy starts a refresh of a single policy.
+func (s *Session) refreshPolicy(name string) error {
+	href, err := s.findElement(FirewallPolicyType, name)
+	if err != nil {
+		return err
+	}
+	if href == "" {
+		return errors.New(fmt.Sprintf("policy %s was not found in SMC", name))
+	}
+
+MethodStart:
+	status, resp, err := s.buildRequest(href+"/refresh", http.MethodPost, map[string]string{"Accept": "application/json"}, nil)
+	if err != nil {
+		return errors.Wrap(err, "There was an error building the policy refresh request")
+	}
+	defer resp.Body.Close()
+
+	if status == http.StatusUnauthorized {
+		s.Login()
+		goto MethodStart
+	}
+	if status != http.StatusOK && status != http.StatusAccepted {
+		return errors.New(fmt.Sprintf("The policy refresh request was unsuccessful. Status Code: %d", status))
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package smc
+
+import (
+	"main/internal/batches"
+	"main/internal/provenance"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// Precedences between safelists and blocklists, configured by list_precedence. Without precedence
+// conflicts are only reported.
+const (
+	PrecedenceNone      = "none"
+	PrecedenceSafelist  = "safelist"
+	PrecedenceBlocklist = "blocklist"
+)
+
+// oppositeKinds maps each kind of list to the kind its items conflict with.
+var oppositeKinds = map[ListKind]ListKind{
+	IPSafelist:   IPBlocklist,
+	IPBlocklist:  IPSafelist,
+	URLSafelist:  URLBlocklist,
+	URLBlocklist: URLSafelist,
+}
+
+// oppositeList returns the list of the same source whose items conflict with those of list.
+func oppositeList(list managedList) managedList {
+	return resolveList(oppositeKinds[list.kind], list.source)
+}
+
+// prevails reports whether additions to a list of the given kind win over its opposite list.
+func prevails(kind ListKind) bool {
+	safe := kind == IPSafelist || kind == URLSafelist
+	switch viper.GetString("list_precedence") {
+	case PrecedenceSafelist:
+		return safe
+	case PrecedenceBlocklist:
+		return !safe
+	}
+	return false
+}
+
+// yields reports whether additions to a list of the given kind are refused when they conflict.
+func yields(kind ListKind) bool {
+	precedence := viper.GetString("list_precedence")
+	return precedence != PrecedenceNone && precedence != "" && !prevails(kind)
+}
+
+// precedenceJob returns a job removing the items the module added to the opposite list of params,
+// when additions to the list of params take precedence. The references to the items are dropped, as
+// the opposite list no longer holds them for any source.
+func precedenceJob(params ListParams) (job, bool) {
+	if !prevails(params.list.kind) {
+		return job{}, false
+	}
+
+	opposite := oppositeList(params.list)
+	owned := provenance.Owned(opposite.key, params.items...)
+	var removals []string
+	for _, item := range params.items {
+		if owned[item] {
+			removals = append(removals, item)
+		}
+	}
+	if len(removals) == 0 {
+		return job{}, false
+	}
+
+	j := listJob(ListParams{
+		removals: removals,
+		safe:     !params.safe,
+		listType: params.listType,
+		list:     opposite,
+		dryRun:   params.dryRun,
+	})
+	if !params.dryRun {
+		j.commit = func(batches.ListResult) {
+			provenance.Forget(opposite.key, removals...)
+		}
+	}
+	return j, true
+}
+
+// conflictCheck is the check of the opposite list for the items a list job adds. The check runs on
+// the lane of the opposite list, so the opposite list is not read while it is being written to, and
+// the list job waits on its lane until the check finished.
+type conflictCheck struct {
+	// list is the opposite list, lane the lane of the list job waiting for the check.
+	list  managedList
+	lane  string
+	items []string
+	// done is set once the check finished, under the pool lock.
+	done        bool
+	conflicting map[string]bool
+	err         error
+}
+
+// newConflictCheck returns the check of the opposite list for the items of params.
+func newConflictCheck(params ListParams) *conflictCheck {
+	return &conflictCheck{list: oppositeList(params.list), lane: params.list.key, items: params.items}
+}
+
+// job returns the job making the check on the lane of the opposite list.
+func (c *conflictCheck) job() job {
+	return job{
+		lane:  c.list.key,
+		name:  c.list.name,
+		check: c,
+		run: func(session *Session, report *listReport) error {
+			return c.run(session)
+		},
+	}
+}
+
+// run records the items which the module added to the opposite list or which are in it.
+func (c *conflictCheck) run(s *Session) error {
+	if err := s.adopt(ListParams{listType: c.list.listType, list: c.list}); err != nil {
+		return err
+	}
+	conflicting := provenance.Owned(c.list.key, c.items...)
+	if storedList(c.list.key).ID != "" {
+		state, err := s.listState(ListParams{listType: c.list.listType, list: c.list})
+		if err != nil && errors.Cause(err) != ErrListNotFound {
+			return errors.Wrap(err, "error checking list "+c.list.name+" for conflicts")
+		}
+		index := state.Index()
+		for _, item := range c.items {
+			if _, ok := index[item]; ok {
+				conflicting[item] = true
+			}
+		}
+	}
+	c.conflicting = conflicting
+	return nil
+}
+
+// checked reports whether the conflict check a job waits for finished. The pool lock must be held.
+func (j job) checked() bool {
+	return j.params == nil || j.params.conflicts == nil || j.params.conflicts.done
+}
+
+// checkFinished records the outcome of a conflict check and schedules the lane waiting for it.
+func (p *pool) checkFinished(c *conflictCheck, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c.done = true
+	c.err = err
+	if l, ok := p.lanes[c.lane]; ok && !l.scheduled && !l.waiting && !l.held && len(l.jobs) > 0 {
+		p.schedule(l)
+	}
+}
+
+// mergeConflictChecks merges the finished conflict checks of coalesced jobs.
+func mergeConflictChecks(jobs []job) *conflictCheck {
+	merged := &conflictCheck{done: true, conflicting: map[string]bool{}}
+	for _, j := range jobs {
+		c := j.params.conflicts
+		if c == nil {
+			continue
+		}
+		if merged.err == nil {
+			merged.err = c.err
+		}
+		for item := range c.conflicting {
+			merged.conflicting[item] = true
+		}
+	}
+	return merged
+}
+
+// resolveConflicts records the items of params which are also in the opposite list, as found by the
+// conflict check. When the opposite list takes precedence the conflicting items are refused and left
+// out of the returned params.
+func resolveConflicts(params ListParams) (ListParams, error) {
+	if len(params.items) == 0 || params.conflicts == nil {
+		return params, nil
+	}
+	if params.conflicts.err != nil {
+		return params, params.conflicts.err
+	}
+
+	var conflicts, items []string
+	for _, item := range params.items {
+		if params.conflicts.conflicting[item] {
+			conflicts = append(conflicts, item)
+			if yields(params.list.kind) {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	if len(conflicts) == 0 {
+		return params, nil
+	}
+	params.report.conflict(conflicts...)
+
+	if yields(params.list.kind) {
+		params.report.result(ItemConflict, conflicts...)
+		params.items = items
+	}
+	return params, nil
+}
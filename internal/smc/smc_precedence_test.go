@@ -0,0 +1,90 @@
+package smc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	items := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}
+	conflicting := map[string]bool{"2.2.2.2": true}
+
+	tests := []struct {
+		name       string
+		precedence string
+		kind       ListKind
+		items      []string
+		refused    []string
+	}{
+		{name: "conflicts are only reported without precedence", precedence: PrecedenceNone, kind: IPBlocklist, items: items},
+		{name: "conflicts are only reported by default", kind: IPSafelist, items: items},
+		{name: "safelist prevails over the blocklist", precedence: PrecedenceSafelist, kind: IPSafelist, items: items},
+		{
+			name:       "blocklist yields to the safelist",
+			precedence: PrecedenceSafelist,
+			kind:       IPBlocklist,
+			items:      []string{"1.1.1.1", "3.3.3.3"},
+			refused:    []string{"2.2.2.2"},
+		},
+		{name: "blocklist prevails over the safelist", precedence: PrecedenceBlocklist, kind: URLBlocklist, items: items},
+		{
+			name:       "safelist yields to the blocklist",
+			precedence: PrecedenceBlocklist,
+			kind:       URLSafelist,
+			items:      []string{"1.1.1.1", "3.3.3.3"},
+			refused:    []string{"2.2.2.2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("list_precedence", test.precedence)
+			defer viper.Set("list_precedence", nil)
+
+			report := newListReport(nil)
+			params := ListParams{
+				items:     items,
+				list:      managedList{key: "list", name: "list", kind: test.kind},
+				report:    report,
+				conflicts: &conflictCheck{done: true, conflicting: conflicting},
+			}
+			resolved, err := resolveConflicts(params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resolved.items, test.items) {
+				t.Errorf("items %v, want %v", resolved.items, test.items)
+			}
+
+			result := report.listResult("list", items, nil)
+			if !reflect.DeepEqual(result.Conflicts, []string{"2.2.2.2"}) {
+				t.Errorf("conflicts %v, want the conflicting item", result.Conflicts)
+			}
+			var refused []string
+			for _, item := range result.Items {
+				if item.Result == ItemConflict {
+					refused = append(refused, item.Value)
+				}
+			}
+			if !reflect.DeepEqual(refused, test.refused) {
+				t.Errorf("refused %v, want %v", refused, test.refused)
+			}
+		})
+	}
+
+	t.Run("failed check fails the job", func(t *testing.T) {
+		failed := errors.New("check failed")
+		params := ListParams{
+			items:     items,
+			list:      managedList{key: "list", name: "list", kind: IPBlocklist},
+			report:    newListReport(nil),
+			conflicts: &conflictCheck{done: true, err: failed},
+		}
+		if _, err := resolveConflicts(params); err != failed {
+			t.Errorf("got error %v, want the error of the check", err)
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package smc
+
+import (
+	"fmt"
+	"main/internal/structs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ErrDiscarded is recorded for jobs an operator discarded while their list was held.
+var ErrDiscarded = errors.New("the changes were discarded by an operator")
+
+// ErrNotHeld is returned when a hold is released or discarded for a list which is not held.
+var ErrNotHeld = errors.New("the list is not held")
+
+// rateSample is the number of changes made to a list at one point in time.
+type rateSample struct {
+	time     time.Time
+	adds     int
+	removals int
+}
+
+// rates tracks the recent changes to each list, so a feed changing a list faster than configured
+// pauses the list instead of being applied.
+var rates = struct {
+	sync.Mutex
+	samples map[string][]rateSample
+}{samples: map[string][]rateSample{}}
+
+// changeCounts returns the additions and removals jobs would make to their list. Dry runs do not
+// change anything.
+func changeCounts(jobs []job) (int, int) {
+	adds, removals := 0, 0
+	for _, j := range jobs {
+		if j.params == nil || j.params.dryRun {
+			continue
+		}
+		adds += len(j.params.items)
+		removals += len(j.params.removals)
+	}
+	return adds, removals
+}
+
+// limit checks whether jobs would exceed the rate of change allowed for their list and records their
+// changes if not. A reason is returned when the lane must be held. The pool lock must be held.
+func (p *pool) limit(l *lane, jobs []job) string {
+	adds, removals := changeCounts(jobs)
+	if adds+removals == 0 {
+		return ""
+	}
+	if l.released >= adds+removals {
+		l.released -= adds + removals
+		recordRate(l.key, adds, removals)
+		return ""
+	}
+
+	window := time.Duration(viper.GetInt("rate_limit_window")) * time.Second
+	maxAdds := viper.GetInt("rate_limit_max_adds")
+	maxRemovals := viper.GetInt("rate_limit_max_removals")
+
+	rates.Lock()
+	defer rates.Unlock()
+
+	recentAdds, recentRemovals := 0, 0
+	var kept []rateSample
+	for _, sample := range rates.samples[l.key] {
+		if time.Since(sample.time) > window {
+			continue
+		}
+		kept = append(kept, sample)
+		recentAdds += sample.adds
+		recentRemovals += sample.removals
+	}
+	rates.samples[l.key] = kept
+
+	if maxAdds > 0 && recentAdds+adds > maxAdds {
+		return fmt.Sprintf("%d additions within %s exceed the limit of %d", recentAdds+adds, window, maxAdds)
+	}
+	if maxRemovals > 0 && recentRemovals+removals > maxRemovals {
+		return fmt.Sprintf("%d removals within %s exceed the limit of %d", recentRemovals+removals, window, maxRemovals)
+	}
+	rates.samples[l.key] = append(kept, rateSample{time: time.Now(), adds: adds, removals: removals})
+	return ""
+}
+
+func recordRate(key string, adds, removals int) {
+	rates.Lock()
+	defer rates.Unlock()
+
+	rates.samples[key] = append(rates.samples[key], rateSample{time: time.Now(), adds: adds, removals: removals})
+}
+
+// hold pauses a lane until an operator releases or discards its jobs and returns the alert to raise.
+// The pool lock must be held.
+func (p *pool) hold(l *lane, reason string) string {
+	l.held = true
+	l.scheduled = false
+	l.heldSince = time.Now()
+	l.reason = reason
+	rememberHold(l.key, reason, l.heldSince)
+
+	// Conflict checks only read the list, they run ahead of the held jobs so the lists they check
+	// for are not held as well.
+	jobs := l.jobs
+	l.jobs = nil
+	for _, j := range jobs {
+		if j.check != nil {
+			p.checkHeld(l, j)
+			continue
+		}
+		l.jobs = append(l.jobs, j)
+		j.hold()
+	}
+
+	return fmt.Sprintf("changes to list %s are paused: %s. Release or discard the held batches %v through /holds", l.jobs[0].name, reason, l.batchIDs())
+}
+
+// checkHeld queues a conflict check on a held lane behind the other checks, ahead of the held jobs,
+// and schedules the lane to run it. The pool lock must be held.
+func (p *pool) checkHeld(l *lane, j job) {
+	n := 0
+	for n < len(l.jobs) && l.jobs[n].check != nil {
+		n++
+	}
+	l.jobs = append(l.jobs[:n:n], append([]job{j}, l.jobs[n:]...)...)
+	if !l.scheduled {
+		p.schedule(l)
+	}
+}
+
+// hold marks the batches of a job as waiting for an operator decision.
+func (j job) hold() {
+	for _, b := range j.batches {
+		b.hold()
+	}
+}
+
+// batchIDs returns the IDs of the batches queued on the lane.
+func (l *lane) batchIDs() []int {
+	seen := map[int]bool{}
+	ids := []int{}
+	for _, j := range l.jobs {
+		for _, id := range j.batchIDs() {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// Holds returns the lists which are paused for changing faster than allowed.
+func Holds() []structs.Hold {
+	holds := []structs.Hold{}
+	if workers == nil {
+		return holds
+	}
+
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+
+	for _, l := range workers.lanes {
+		if !l.held {
+			continue
+		}
+		adds, removals := changeCounts(l.jobs)
+		holds = append(holds, structs.Hold{
+			List:     l.key,
+			Name:     l.jobs[0].name,
+			Reason:   l.reason,
+			Since:    l.heldSince,
+			Adds:     adds,
+			Removals: removals,
+			Batches:  l.batchIDs(),
+		})
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		return holds[i].Since.Before(holds[j].Since)
+	})
+	return holds
+}
+
+// ReleaseHold resumes a paused list. The jobs queued at the time are applied regardless of the rate
+// limit.
+func ReleaseHold(key string) error {
+	if workers == nil {
+		return ErrNotHeld
+	}
+
+	workers.mu.Lock()
+	l, ok := workers.lanes[key]
+	if !ok || !l.held {
+		workers.mu.Unlock()
+		return ErrNotHeld
+	}
+	jobs := l.jobs
+	adds, removals := changeCounts(jobs)
+	l.held = false
+	l.released = adds + removals
+	forgetHold(key)
+	if !l.scheduled {
+		workers.schedule(l)
+	}
+	workers.mu.Unlock()
+
+	// Batches are resumed outside of the pool lock, as finishing batches submit jobs.
+	logrus.Infof("changes to list %s were released by an operator", jobs[0].name)
+	for _, j := range jobs {
+		for _, b := range j.batches {
+			b.resume()
+		}
+	}
+	return nil
+}
+
+// DiscardHold drops the jobs queued on a paused list. Their batches fail for that list.
+func DiscardHold(key string) error {
+	if workers == nil {
+		return ErrNotHeld
+	}
+
+	workers.mu.Lock()
+	l, ok := workers.lanes[key]
+	if !ok || !l.held {
+		workers.mu.Unlock()
+		return ErrNotHeld
+	}
+	// Conflict checks queued on the lane are kept, they belong to jobs on other lists.
+	var jobs, checks []job
+	for _, j := range l.jobs {
+		if j.check != nil {
+			checks = append(checks, j)
+		} else {
+			jobs = append(jobs, j)
+		}
+	}
+	l.held = false
+	forgetHold(key)
+	l.jobs = checks
+	l.items = 0
+	if len(checks) == 0 {
+		delete(workers.lanes, key)
+	} else if !l.scheduled {
+		workers.schedule(l)
+	}
+	workers.mu.Unlock()
+
+	logrus.Warnf("changes to list %s were discarded by an operator", jobs[0].name)
+	for _, j := range jobs {
+		j.finish(newListReport(j.batchIDs()), ErrDiscarded)
+	}
+	return nil
+}
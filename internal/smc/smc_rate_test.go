@@ -0,0 +1,108 @@
+package smc
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestLimit(t *testing.T) {
+	viper.Set("rate_limit_window", 60)
+	viper.Set("rate_limit_max_adds", 3)
+	viper.Set("rate_limit_max_removals", 2)
+	defer viper.Set("rate_limit_window", nil)
+	defer viper.Set("rate_limit_max_adds", nil)
+	defer viper.Set("rate_limit_max_removals", nil)
+
+	list := managedList{key: "limited", name: "limited"}
+	changes := func(adds, removals int) job {
+		params := ListParams{list: list}
+		for i := 0; i < adds; i++ {
+			params.items = append(params.items, string(rune('a'+i)))
+		}
+		for i := 0; i < removals; i++ {
+			params.removals = append(params.removals, string(rune('A'+i)))
+		}
+		return job{lane: list.key, params: &params}
+	}
+
+	tests := []struct {
+		name     string
+		released int
+		jobs     []job
+		held     bool
+	}{
+		{name: "additions up to the limit", jobs: []job{changes(3, 0)}},
+		{name: "additions over the limit", jobs: []job{changes(4, 0)}, held: true},
+		{name: "additions of coalesced jobs over the limit", jobs: []job{changes(2, 0), changes(2, 0)}, held: true},
+		{name: "removals over the limit", jobs: []job{changes(0, 3)}, held: true},
+		{name: "released changes bypass the limit", released: 4, jobs: []job{changes(4, 0)}},
+		{name: "dry runs are not limited", jobs: []job{func() job { j := changes(5, 5); j.params.dryRun = true; return j }()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rates.Lock()
+			delete(rates.samples, list.key)
+			rates.Unlock()
+
+			p := &pool{lanes: map[string]*lane{}}
+			l := &lane{key: list.key, released: test.released}
+			if reason := p.limit(l, test.jobs); (reason != "") != test.held {
+				t.Errorf("limit returned %q, want held %t", reason, test.held)
+			}
+		})
+	}
+
+	t.Run("changes within the window count", func(t *testing.T) {
+		rates.Lock()
+		delete(rates.samples, list.key)
+		rates.Unlock()
+
+		p := &pool{lanes: map[string]*lane{}}
+		l := &lane{key: list.key}
+		if reason := p.limit(l, []job{changes(2, 0)}); reason != "" {
+			t.Fatalf("first changes held: %s", reason)
+		}
+		if reason := p.limit(l, []job{changes(2, 0)}); reason == "" {
+			t.Error("changes over the limit within the window were not held")
+		}
+	})
+}
+
+func TestHeldLaneRunsConflictChecks(t *testing.T) {
+	p := &pool{lanes: map[string]*lane{}}
+	p.cond = sync.NewCond(&p.mu)
+
+	list := managedList{key: "held", name: "held"}
+	queued := job{lane: list.key, params: &ListParams{list: list, items: []string{"1.1.1.1"}}}
+	early := (&conflictCheck{list: list}).job()
+	l := &lane{key: list.key, jobs: []job{queued, early}, items: 1}
+	p.lanes[list.key] = l
+
+	p.mu.Lock()
+	p.hold(l, "test")
+	late := (&conflictCheck{list: list}).job()
+	p.enqueue(late)
+	p.mu.Unlock()
+
+	if len(l.jobs) != 3 || l.jobs[0].check != early.check || l.jobs[1].check != late.check || l.jobs[2].params != queued.params {
+		t.Fatalf("conflict checks are not queued ahead of the held jobs: %+v", l.jobs)
+	}
+	if len(p.ready) != 1 || p.ready[0] != l {
+		t.Fatal("the held lane was not scheduled to run its conflict checks")
+	}
+
+	workers = p
+	defer func() { workers = nil }()
+	if err := DiscardHold(list.key); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.jobs) != 2 || l.jobs[0].check == nil || l.jobs[1].check == nil {
+		t.Errorf("discarding the hold dropped the conflict checks: %+v", l.jobs)
+	}
+	if p.lanes[list.key] != l {
+		t.Error("the lane of the remaining conflict checks was dropped")
+	}
+}
@@ -0,0 +1,188 @@
+package smc
+
+import (
+	"io"
+	"io/ioutil"
+	"main/internal/batches"
+	"main/internal/events"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Item results recorded in the batch history.
+const (
+	ItemAdded     = batches.ItemAdded
+	ItemPresent   = batches.ItemPresent
+	ItemRemoved   = batches.ItemRemoved
+	ItemAbsent    = "not_present"
+	ItemFailed    = "failed"
+	ItemDeferred  = "deferred"
+	ItemCancelled = "cancelled"
+	// ItemReferenced items were not removed as other sources still reference them.
+	ItemReferenced = "still_referenced"
+	// ItemConflict items were not added as they are in the opposite list, which takes precedence.
+	ItemConflict = "refused_conflict"
+	// ItemProtected items were not blocklisted as they overlap the protected addresses and domains.
+	ItemProtected = "rejected_protected"
+	// ItemForeign items were not removed as they were not added by the module.
+	ItemForeign = "not_owned"
+	// ItemInvalid snort rules were not added as they are malformed or their SID is taken.
+	ItemInvalid = "rejected_invalid"
+	// ItemAwaitingApproval items of a dry run would be held until an operator approves them.
+	ItemAwaitingApproval = "awaiting_approval"
+	// ItemRejected items were not changed as an operator rejected the change.
+	ItemRejected = "rejected_by_operator"
+	// Results of dry runs.
+	ItemWouldAdd    = "would_add"
+	ItemWouldRemove = "would_remove"
+)
+
+// maxResponseBody is the number of bytes of an SMC error response kept in the batch history.
+const maxResponseBody = 512
+
+// listReport collects the item results and SMC responses of a job for the batch history. A nil
+// report discards everything.
+type listReport struct {
+	mu        sync.Mutex
+	results   map[string]string
+	responses []batches.Response
+	// batches are the IDs of the batches the job applies, used to address progress events.
+	batches []int
+	// createList is set when a dry run found that the list would be created.
+	createList bool
+	// files are the files of the global snort configuration a dry run would write.
+	files []string
+	// conflicts are the items which are also in the opposite list.
+	conflicts []string
+}
+
+func newListReport(batchIDs []int) *listReport {
+	return &listReport{results: map[string]string{}, batches: batchIDs}
+}
+
+// result records the result of the given items.
+func (r *listReport) result(result string, items ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, item := range items {
+		r.results[item] = result
+	}
+}
+
+// response records a response received from SMC. The body of error responses is kept, so it
+// should not be read afterwards.
+func (r *listReport) response(s *Session, method, url string, resp *http.Response) {
+	if r == nil || resp == nil {
+		return
+	}
+
+	summary := batches.Response{
+		Method: method,
+		Path:   strings.TrimPrefix(url, s.Host+":"+s.Port),
+		Status: resp.StatusCode,
+		Time:   time.Now(),
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+		summary.Body = string(body)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.responses = append(r.responses, summary)
+}
+
+// create records that a dry run would create the list.
+func (r *listReport) create() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.createList = true
+}
+
+// conflict records items which are also in the opposite list.
+func (r *listReport) conflict(items ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.conflicts = append(r.conflicts, items...)
+}
+
+// touch records the snort configuration files a dry run would write.
+func (r *listReport) touch(files ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.files = append(r.files, files...)
+}
+
+// touchedFiles returns the snort configuration files a dry run would write.
+func (r *listReport) touchedFiles() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.files
+}
+
+// retry publishes that a change to a list is attempted again.
+func (r *listReport) retry(list, message string) {
+	var batchIDs []int
+	if r != nil {
+		batchIDs = r.batches
+	}
+	events.Publish(events.Event{Type: events.Retry, Batches: batchIDs, List: list, Message: message})
+}
+
+// listResult returns the outcome of a job for the given items. Items without a result failed with
+// the job's error, or were cancelled out by coalescing if the job succeeded.
+func (r *listReport) listResult(list string, items []string, err error) batches.ListResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := batches.ListResult{List: list, CreateList: r.createList, Counts: map[string]int{}, Responses: r.responses}
+	// Conflicts are reported for the items of this part of the job only.
+	requested := map[string]bool{}
+	for _, item := range items {
+		requested[item] = true
+	}
+	for _, item := range r.conflicts {
+		if requested[item] {
+			result.Conflicts = append(result.Conflicts, item)
+		}
+	}
+	missing := ItemCancelled
+	if errors.Cause(err) == ErrCircuitOpen {
+		missing = ItemDeferred
+	} else if err != nil {
+		result.Error = err.Error()
+		missing = ItemFailed
+	}
+
+	for _, item := range items {
+		itemResult, ok := r.results[item]
+		if !ok || err != nil {
+			itemResult = missing
+		}
+		result.Items = append(result.Items, batches.ItemResult{Value: item, Result: itemResult})
+		result.Counts[itemResult]++
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package smc
+
+import (
+	"fmt"
+	"main/internal/batches"
+	"main/internal/events"
+	"main/internal/provenance"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Rollback queues the reversal of the changes a batch applied to its lists. Items the batch added
+// are removed unless other batches added or rely on them, items it removed are added again. The
+// result is reported to the controller as the status of the batch.
+func Rollback(id int) error {
+	if workers == nil || !Available() {
+		return ErrNoSession
+	}
+
+	changes, err := batches.StartRollback(id)
+	if err != nil {
+		return err
+	}
+
+	var jobs []job
+	for _, change := range changes {
+		if len(change.Added) == 0 && len(change.Removed) == 0 && len(change.Released) == 0 {
+			continue
+		}
+		list, current := resolveKey(change.Key)
+		if list.kind == "" {
+			batches.SetRollbackState(id, batches.Failed)
+			return errors.New(fmt.Sprintf("batch %d changed the unknown list %s", id, change.List))
+		}
+		if !current {
+			list.name = change.List
+		}
+		// Added items are only removed once no other source references them. The references of the
+		// batch are released once the list was written, and the references the batch released are
+		// recorded again for the items which are in the list again.
+		key, added, released := change.Key, change.Added, change.Released
+		restore := func(refused map[string]bool) {
+			provenance.ReleaseBatch(key, id, added...)
+			restored := map[string][]string{}
+			readded := map[string]bool{}
+			for source, values := range released {
+				for _, value := range values {
+					if !refused[value] {
+						restored[source] = append(restored[source], value)
+						readded[value] = true
+					}
+				}
+			}
+			provenance.Record(key, 0, restored, readded)
+		}
+		removals := provenance.UnreferencedBatch(change.Key, id, change.Added...)
+		if len(removals) == 0 && len(change.Removed) == 0 {
+			restore(nil)
+			continue
+		}
+		j := listJob(ListParams{
+			items:    change.Removed,
+			removals: removals,
+			safe:     list.kind == IPSafelist || list.kind == URLSafelist,
+			listType: list.listType,
+			list:     list,
+		})
+		// Items refused by the opposite list are not added back.
+		j.commit = func(result batches.ListResult) {
+			refused := map[string]bool{}
+			for _, item := range result.Items {
+				if item.Result == ItemConflict {
+					refused[item.Value] = true
+				}
+			}
+			restore(refused)
+		}
+		jobs = append(jobs, j)
+	}
+
+	logrus.Infof("rolling back batch %d on %d lists", id, len(jobs))
+	events.Publish(events.Event{Type: events.Queued, Batches: []int{id}, Message: "rollback"})
+
+	b := &batch{id: id, pending: len(jobs), rollback: true}
+	if len(jobs) == 0 {
+		b.pending = 1
+		b.done(nil)
+		return nil
+	}
+	for _, j := range jobs {
+		j.batches = []*batch{b}
+		workers.submit(j)
+	}
+	return nil
+}
@@ -0,0 +1,493 @@
+package smc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"main/internal/snort"
+	"main/internal/util"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// managedSnortFile names the rule file the module writes, or the files when there is one per source.
+const managedSnortFile = "dim_managed_snorts"
+
+// managedSnortHeader is written at the top of the managed rule files.
+const managedSnortHeader = "# Managed by the DIM SMC module, changes to this file are overwritten."
+
+// legacySnortFilePattern matches the rule files written by earlier versions, one per batch.
+var legacySnortFilePattern = regexp.MustCompile(`^dim_snorts_\d+\.config$`)
+
+// unsafeFileChars matches the characters of a source which are not used in file names.
+var unsafeFileChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// sidPattern matches the sid option of a snort rule.
+var sidPattern = regexp.MustCompile(`(?:^|[;(\s])sid\s*:\s*(\d+)\s*;`)
+
+// requestedSIDPattern matches removals which name a rule by its SID rather than by its text.
+var requestedSIDPattern = regexp.MustCompile(`^(?:sid\s*:\s*)?(\d+)\s*;?$`)
+
+// snortFile returns the name of the managed rule file the rules of a source are written to.
+func snortFile(source string) string {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(strings.ToLower(source), "_"), "_")
+	if !viper.GetBool("snort_file_per_source") || name == "" {
+		return managedSnortFile + ".config"
+	}
+	return fmt.Sprintf("%s_%s.config", managedSnortFile, name)
+}
+
+// managedSnortFiles returns the paths of the rule files written by the module in an exported
+// configuration, including those of earlier versions.
+func managedSnortFiles(exportDirPath string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(exportDirPath, managedSnortFile+"*.config"))
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := legacySnortFiles(exportDirPath)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, legacy...), nil
+}
+
+// legacySnortFiles returns the paths of the rule files written by earlier versions.
+func legacySnortFiles(exportDirPath string) ([]string, error) {
+	candidates, err := filepath.Glob(filepath.Join(exportDirPath, "dim_snorts_*.config"))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, path := range candidates {
+		if legacySnortFilePattern.MatchString(filepath.Base(path)) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// readSnortRules returns the rules of a rule file, without blank lines and comments. A missing file
+// has no rules.
+func readSnortRules(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error in reading "+filepath.Base(path))
+	}
+
+	var rules []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			rules = append(rules, line)
+		}
+	}
+	return rules, nil
+}
+
+// writeSnortRules replaces the content of a managed rule file.
+func writeSnortRules(path string, rules []string) error {
+	content := managedSnortHeader + "\n" + strings.Join(rules, "\n") + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		return errors.Wrap(err, "Error in writing "+filepath.Base(path))
+	}
+	return nil
+}
+
+// migrateSnortFiles moves the rules of the files written by earlier versions into the managed rule
+// file, dropping duplicates, and removes those files along with their include lines. It reports
+// whether the configuration changed.
+func migrateSnortFiles(exportDirPath string) (bool, error) {
+	legacy, err := legacySnortFiles(exportDirPath)
+	if err != nil || len(legacy) == 0 {
+		return false, err
+	}
+
+	name := managedSnortFile + ".config"
+	path := filepath.Join(exportDirPath, name)
+	rules, err := readSnortRules(path)
+	if err != nil {
+		return false, err
+	}
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		seen[rule] = true
+	}
+
+	migrated := 0
+	for _, file := range legacy {
+		legacyRules, err := readSnortRules(file)
+		if err != nil {
+			return false, err
+		}
+		for _, rule := range legacyRules {
+			if !seen[rule] {
+				seen[rule] = true
+				rules = append(rules, rule)
+				migrated++
+			}
+		}
+		if err := os.Remove(file); err != nil {
+			return false, errors.Wrap(err, "Error in removing "+filepath.Base(file))
+		}
+		if err := util.SmcRulesExclude(exportDirPath, filepath.Base(file)); err != nil {
+			return false, errors.Wrap(err, "Error in removing the snort file from the rule include file")
+		}
+	}
+
+	if err := writeSnortRules(path, rules); err != nil {
+		return false, err
+	}
+	if err := util.SmcRulesInclude(exportDirPath, name); err != nil {
+		return false, errors.Wrap(err, "Error in adding the snort file to the rule include file")
+	}
+	logrus.Infof("migrated %d rules from %d snort files of earlier versions into %s", migrated, len(legacy), name)
+	return true, nil
+}
+
+// addSnorts writes rules into their managed rule files and imports the global snort configuration.
+// Rules already in the configuration are left as they are, so the files only change when new rules
+// are added. Invalid rules, and rules whose SID is taken by another rule, are rejected without
+// failing the others. Dry runs only report the rules which would be added.
+func (s *Session) addSnorts(files map[string][]string, dryRun bool, report *listReport) error {
+	exportDirPath, err := s.RetrieveGlobalSnortConfig()
+	if err != nil {
+		return errors.Wrap(err, "Error in retrieving global snort config")
+	}
+
+	changed := false
+	if !dryRun {
+		if changed, err = migrateSnortFiles(exportDirPath); err != nil {
+			return errors.Wrap(err, "Error in migrating the snort files of earlier versions")
+		}
+	}
+
+	index, err := indexSnortConfig(exportDirPath)
+	if err != nil {
+		return errors.Wrap(err, "Error in indexing the snort rules")
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var added []string
+	for _, name := range names {
+		path := filepath.Join(exportDirPath, name)
+		rules, err := readSnortRules(path)
+		if err != nil {
+			return err
+		}
+
+		var fileAdded []string
+		for _, value := range files[name] {
+			text, replaced, reason := placeSnort(value, name, rules, index)
+			switch {
+			case reason != "":
+				logrus.Warnf("rejecting snort rule %s: %s", value, reason)
+				report.result(ItemInvalid, value)
+				continue
+			case text == "":
+				report.result(ItemPresent, value)
+				continue
+			case replaced >= 0:
+				rules[replaced] = text
+			default:
+				rules = append(rules, text)
+			}
+			fileAdded = append(fileAdded, value)
+		}
+		if len(fileAdded) == 0 {
+			continue
+		}
+
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			report.touch(name, util.RulesIncludeFile)
+		} else {
+			report.touch(name)
+		}
+		if dryRun {
+			report.result(ItemWouldAdd, fileAdded...)
+			continue
+		}
+
+		if err := writeSnortRules(path, rules); err != nil {
+			return errors.Wrap(err, "Error in saving new snorts")
+		}
+		if err := util.SmcRulesInclude(exportDirPath, name); err != nil {
+			return errors.Wrap(err, "Error in adding the snort file to the rule include file")
+		}
+		added = append(added, fileAdded...)
+		changed = true
+	}
+
+	if changed {
+		if err := s.ImportGlobalSnortConfig(exportDirPath); err != nil {
+			return errors.Wrap(err, "Error in importing global snort config")
+		}
+	}
+
+	report.result(ItemAdded, added...)
+	return nil
+}
+
+// placeSnort decides how a rule is written to a managed rule file holding rules. The text to write
+// is returned, empty if the rule is already in the configuration, with the index of the rule it
+// replaces or -1. Rules without a SID are given one from the local range when it is configured, a
+// newer revision of a rule in the same file replaces it. The reason is set when the rule is rejected.
+func placeSnort(value, file string, rules []string, index *snort.Index) (string, int, string) {
+	rule, err := snort.Parse(value)
+	if err != nil {
+		return "", -1, err.Error()
+	}
+	for _, existing := range rules {
+		if existing == value {
+			return "", -1, ""
+		}
+	}
+
+	text := value
+	sid := rule.SID()
+	minSID, maxSID := viper.GetInt("snort_sid_min"), viper.GetInt("snort_sid_max")
+	if sid == 0 && minSID > 0 && maxSID >= minSID {
+		// A rule given a SID earlier is recognised by its signature, so it is not added again.
+		for _, existing := range rules {
+			if parsed, err := snort.Parse(existing); err == nil && parsed.Signature() == rule.Signature() {
+				return "", -1, ""
+			}
+		}
+		next, ok := index.NextSID(minSID, maxSID)
+		if !ok {
+			return "", -1, fmt.Sprintf("no SID is left in the local range %d-%d", minSID, maxSID)
+		}
+		rule.SetSID(next)
+		sid = next
+		text = rule.String()
+	}
+	if sid == 0 {
+		return text, -1, ""
+	}
+
+	indexed, ok := index.Lookup(sid)
+	switch {
+	case !ok:
+	case indexed.Text == text:
+		return "", -1, ""
+	case indexed.File == file && indexed.Rule.Signature() == rule.Signature() && rule.Rev() > indexed.Rule.Rev():
+		for i, existing := range rules {
+			if existing == indexed.Text {
+				index.Add(file, text)
+				return text, i, ""
+			}
+		}
+	default:
+		return "", -1, fmt.Sprintf("sid %d rev %d collides with a rule in %s", sid, indexed.Rule.Rev(), indexed.File)
+	}
+	index.Add(file, text)
+	return text, -1, ""
+}
+
+// indexSnortConfig indexes the rules of every rule file of an exported configuration by their SID.
+func indexSnortConfig(exportDirPath string) (*snort.Index, error) {
+	index := snort.NewIndex()
+	files, err := filepath.Glob(filepath.Join(exportDirPath, "*.config"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		name := filepath.Base(path)
+		if name == util.RulesIncludeFile {
+			continue
+		}
+		rules, err := readSnortRules(path)
+		if err != nil {
+			return nil, err
+		}
+		// Rules which cannot be parsed do not take up their SID, as far as the module can tell.
+		for _, rule := range rules {
+			if err := index.Add(name, rule); err != nil {
+				logrus.Warnf("the rule %q in %s cannot be parsed and its SID is not known: %s", rule, name, err)
+			}
+		}
+	}
+	return index, nil
+}
+
+// snortRemovalJob returns a job removing a rule from the snort files managed by the module.
+func snortRemovalJob(rule string, dryRun bool) job {
+	return job{
+		lane:  snortLane,
+		name:  snortLane,
+		items: []string{rule},
+		run: func(session *Session, report *listReport) error {
+			return session.removeSnorts([]string{rule}, dryRun, report)
+		},
+	}
+}
+
+// snortSID returns the SID of a snort rule, or an empty string if it has none.
+func snortSID(rule string) string {
+	if match := sidPattern.FindStringSubmatch(rule); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// snortRemoval is a requested removal of a snort rule, which names the rule by its SID or its text.
+type snortRemoval struct {
+	value string
+	sid   string
+	// signature identifies a rule named by its text without a SID, which may have been given a SID
+	// from the local range when it was added.
+	signature string
+}
+
+// parseSnortRemovals prepares requested removals for matching against the lines of rule files.
+func parseSnortRemovals(values []string) []snortRemoval {
+	removals := make([]snortRemoval, len(values))
+	for i, value := range values {
+		removals[i] = snortRemoval{value: value}
+		if match := requestedSIDPattern.FindStringSubmatch(value); match != nil {
+			removals[i].sid = match[1]
+			continue
+		}
+		if rule, err := snort.Parse(value); err == nil && rule.SID() == 0 {
+			removals[i].signature = rule.Signature()
+		}
+	}
+	return removals
+}
+
+// matchSnort returns the requested removal a line of a rule file matches. Removals match rules by
+// their SID, by their exact text, or by their signature when they were requested without a SID.
+func matchSnort(line string, removals []snortRemoval) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	sid := snortSID(line)
+	signature, parsed := "", false
+	for _, removal := range removals {
+		switch {
+		case removal.sid != "":
+			if removal.sid == sid {
+				return removal.value, true
+			}
+			continue
+		case removal.value == line:
+			return removal.value, true
+		case removal.signature == "":
+			continue
+		}
+		if !parsed {
+			parsed = true
+			if rule, err := snort.Parse(line); err == nil {
+				signature = rule.Signature()
+			}
+		}
+		if signature == removal.signature {
+			return removal.value, true
+		}
+	}
+	return "", false
+}
+
+// removeSnorts removes rules from the snort files managed by the module and imports the global snort
+// configuration. Files left without any rule are removed along with their include line. Dry runs
+// only report the rules which would be removed.
+func (s *Session) removeSnorts(rules []string, dryRun bool, report *listReport) error {
+	exportDirPath, err := s.RetrieveGlobalSnortConfig()
+	if err != nil {
+		return errors.Wrap(err, "Error in retrieving global snort config")
+	}
+
+	changed := false
+	if !dryRun {
+		if changed, err = migrateSnortFiles(exportDirPath); err != nil {
+			return errors.Wrap(err, "Error in migrating the snort files of earlier versions")
+		}
+	}
+
+	files, err := managedSnortFiles(exportDirPath)
+	if err != nil {
+		return errors.Wrap(err, "Error in listing the managed snort files")
+	}
+
+	removals := parseSnortRemovals(rules)
+	found := map[string]bool{}
+	for _, path := range files {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "Error in reading "+filepath.Base(path))
+		}
+
+		var kept []string
+		removed, remaining := false, 0
+		for _, line := range strings.Split(string(content), "\n") {
+			if removal, ok := matchSnort(line, removals); ok {
+				found[removal] = true
+				removed = true
+				continue
+			}
+			kept = append(kept, line)
+			if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+				remaining++
+			}
+		}
+		if !removed {
+			continue
+		}
+		changed = true
+
+		name := filepath.Base(path)
+		if remaining == 0 {
+			report.touch(name, util.RulesIncludeFile)
+		} else {
+			report.touch(name)
+		}
+		if dryRun {
+			continue
+		}
+
+		if remaining > 0 {
+			if err := ioutil.WriteFile(path, []byte(strings.Join(kept, "\n")), 0644); err != nil {
+				return errors.Wrap(err, "Error in writing "+name)
+			}
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return errors.Wrap(err, "Error in removing "+name)
+		}
+		if err := util.SmcRulesExclude(exportDirPath, name); err != nil {
+			return errors.Wrap(err, "Error in removing the snort file from the rule include file")
+		}
+		logrus.Infof("removed the snort file %s which has no rules left", name)
+	}
+
+	if changed && !dryRun {
+		if err := s.ImportGlobalSnortConfig(exportDirPath); err != nil {
+			return errors.Wrap(err, "Error in importing global snort config")
+		}
+	}
+
+	for _, rule := range rules {
+		switch {
+		case !found[rule]:
+			report.result(ItemAbsent, rule)
+		case dryRun:
+			report.result(ItemWouldRemove, rule)
+		default:
+			report.result(ItemRemoved, rule)
+		}
+	}
+	return nil
+}
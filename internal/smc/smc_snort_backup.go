@@ -0,0 +1,220 @@
+package smc
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"main/internal/structs"
+	"main/internal/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// snortTempDirPath is the dir the global snort configuration is exported to and imported from.
+var snortTempDirPath = filepath.Join("/temp", "snort")
+
+// snortVerifyDirPath is the dir the global snort configuration is exported to after an import, to
+// confirm it was applied.
+var snortVerifyDirPath = filepath.Join("/temp", "snort-verify")
+
+// exportedSnortZip is the name of the exported global snort configuration.
+const exportedSnortZip = "global-smc-snorts.zip"
+
+// snortBackupTimeFormat names the backups after the time they were taken, so they sort by age.
+const snortBackupTimeFormat = "20060102T150405.000000000Z"
+
+// ErrBackupNotFound is returned when a snort configuration backup is restored which does not exist.
+var ErrBackupNotFound = errors.New("the snort configuration backup was not found")
+
+// backupSnortConfig saves the last exported global snort configuration in the backup dir, dropping
+// the oldest backups beyond the configured number. The path of the backup is returned, or an empty
+// string when SMC had no configuration to export.
+func backupSnortConfig() (string, error) {
+	exported := filepath.Join(snortTempDirPath, exportedSnortZip)
+	src, err := os.Open(exported)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dir := viper.GetString("snort_backup_dir")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", errors.Wrap(err, "error creating the snort backup dir")
+	}
+	path := filepath.Join(dir, time.Now().UTC().Format(snortBackupTimeFormat)+".zip")
+	dst, err := os.Create(path)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating the snort backup")
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return "", errors.Wrap(err, "error writing the snort backup")
+	}
+
+	pruneSnortBackups()
+	return path, nil
+}
+
+// pruneSnortBackups removes the oldest backups beyond the configured number.
+func pruneSnortBackups() {
+	max := viper.GetInt("snort_backup_max")
+	if max < 1 {
+		return
+	}
+	backups := SnortBackups()
+	if len(backups) <= max {
+		return
+	}
+	for _, backup := range backups[max:] {
+		if err := os.Remove(snortBackupPath(backup.ID)); err != nil {
+			logrus.Warn("error removing the snort backup ", backup.ID, ": ", err)
+		}
+	}
+}
+
+func snortBackupPath(id string) string {
+	return filepath.Join(viper.GetString("snort_backup_dir"), id+".zip")
+}
+
+// SnortBackups returns the backups of the global snort configuration, newest first.
+func SnortBackups() []structs.SnortBackup {
+	backups := []structs.SnortBackup{}
+	entries, err := ioutil.ReadDir(viper.GetString("snort_backup_dir"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Error("error listing the snort backups: ", err)
+		}
+		return backups
+	}
+
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".zip")
+		created, err := time.Parse(snortBackupTimeFormat, id)
+		if entry.IsDir() || err != nil || id == entry.Name() {
+			continue
+		}
+		backups = append(backups, structs.SnortBackup{ID: id, Created: created, Size: entry.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Created.After(backups[j].Created)
+	})
+	return backups
+}
+
+// RestoreSnortBackup queues the import of a backup of the global snort configuration. The restore is
+// serialized with the other changes to the snort configuration, and backs up the configuration it
+// replaces like any other import.
+func RestoreSnortBackup(id string) error {
+	path := snortBackupPath(id)
+	if _, err := time.Parse(snortBackupTimeFormat, id); err != nil {
+		return ErrBackupNotFound
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ErrBackupNotFound
+	}
+	if workers == nil {
+		return ErrNoSession
+	}
+
+	workers.submit(job{
+		lane: snortLane,
+		name: snortLane,
+		run: func(session *Session, report *listReport) error {
+			return session.restoreSnortBackup(path)
+		},
+	})
+	return nil
+}
+
+// restoreSnortBackup replaces the global snort configuration with a backup.
+func (s *Session) restoreSnortBackup(path string) error {
+	exportDirPath, err := s.RetrieveGlobalSnortConfig()
+	if err != nil {
+		return errors.Wrap(err, "Error in retrieving global snort config")
+	}
+	if err := os.RemoveAll(exportDirPath); err != nil {
+		return errors.Wrap(err, "error clearing the snort export dir")
+	}
+	if _, err := util.Unzip(path, exportDirPath); err != nil {
+		return errors.Wrap(err, "error unzipping the snort backup")
+	}
+
+	if err := s.ImportGlobalSnortConfig(exportDirPath); err != nil {
+		return errors.Wrap(err, "error restoring the snort backup "+filepath.Base(path))
+	}
+	logrus.Infof("restored the global snort configuration from %s", filepath.Base(path))
+	return nil
+}
+
+// verifySnortConfig exports the global snort configuration again and compares it with the files
+// which were imported. Managed rule files are compared both ways, so a managed file which is still
+// there after it was dropped from the import is found as well.
+func (s *Session) verifySnortConfig(exportDirPath string) error {
+	verifyDirPath, err := s.exportSnortConfig(snortVerifyDirPath)
+	if err != nil {
+		return errors.Wrap(err, "error exporting the imported global snort configuration")
+	}
+
+	err = filepath.Walk(exportDirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(exportDirPath, path)
+		if err != nil {
+			return err
+		}
+		imported, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		exported, err := ioutil.ReadFile(filepath.Join(verifyDirPath, rel))
+		if os.IsNotExist(err) {
+			return errors.New(fmt.Sprintf("%s is missing from the imported global snort configuration", rel))
+		}
+		if err != nil {
+			return err
+		}
+		if normalizeSnortFile(imported) != normalizeSnortFile(exported) {
+			return errors.New(fmt.Sprintf("%s differs in the imported global snort configuration", rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// A managed file which was dropped from the import must be gone from the configuration as well.
+	managed, err := managedSnortFiles(verifyDirPath)
+	if err != nil {
+		return err
+	}
+	for _, path := range managed {
+		name := filepath.Base(path)
+		if _, err := os.Stat(filepath.Join(exportDirPath, name)); os.IsNotExist(err) {
+			return errors.New(fmt.Sprintf("%s is still in the imported global snort configuration", name))
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeSnortFile drops the differences in line endings and trailing whitespace SMC may introduce.
+func normalizeSnortFile(content []byte) string {
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,194 @@
+package smc
+
+import (
+	"main/internal/snort"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestPlaceSnort(t *testing.T) {
+	const file = "dim_managed_snorts.config"
+	existing := map[string][]string{
+		file: {
+			`alert tcp any any -> any 80 (msg:"web"; sid:1000000; rev:1;)`,
+			`alert tcp any any -> any 22 (msg:"ssh"; sid:5000; rev:2;)`,
+		},
+		"local.config": {
+			`alert tcp any any -> any 25 (msg:"smtp"; sid:6000; rev:1;)`,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		min, max int
+		text     string
+		replaced int
+		// rejected is set when the rule is refused.
+		rejected bool
+	}{
+		{
+			name:     "new rule with sid",
+			value:    `alert tcp any any -> any 443 (msg:"tls"; sid:7000; rev:1;)`,
+			text:     `alert tcp any any -> any 443 (msg:"tls"; sid:7000; rev:1;)`,
+			replaced: -1,
+		},
+		{
+			name:     "rule without sid outside of a local range",
+			value:    `alert tcp any any -> any 443 (msg:"tls";)`,
+			text:     `alert tcp any any -> any 443 (msg:"tls";)`,
+			replaced: -1,
+		},
+		{
+			name:     "rule without sid is given the next free local sid",
+			value:    `alert tcp any any -> any 443 (msg:"tls";)`,
+			min:      1000000,
+			max:      1000010,
+			text:     `alert tcp any any -> any 443 (msg:"tls"; sid:1000001; rev:1;)`,
+			replaced: -1,
+		},
+		{
+			name:     "rule given a local sid earlier is not added again",
+			value:    `alert tcp any any -> any 80 (msg:"web";)`,
+			min:      1000000,
+			max:      1000010,
+			replaced: -1,
+		},
+		{
+			name:     "exhausted local range",
+			value:    `alert tcp any any -> any 443 (msg:"tls";)`,
+			min:      1000000,
+			max:      1000000,
+			replaced: -1,
+			rejected: true,
+		},
+		{
+			name:     "identical rule is skipped",
+			value:    `alert tcp any any -> any 22 (msg:"ssh"; sid:5000; rev:2;)`,
+			replaced: -1,
+		},
+		{
+			name:     "newer revision replaces the rule",
+			value:    `alert tcp any any -> any 22 (msg:"ssh"; sid:5000; rev:3;)`,
+			text:     `alert tcp any any -> any 22 (msg:"ssh"; sid:5000; rev:3;)`,
+			replaced: 1,
+		},
+		{
+			name:     "older revision collides",
+			value:    `alert tcp any any -> any 22 (msg:"ssh"; sid:5000; rev:1;)`,
+			replaced: -1,
+			rejected: true,
+		},
+		{
+			name:     "different rule with a taken sid collides",
+			value:    `alert tcp any any -> any 23 (msg:"telnet"; sid:5000; rev:3;)`,
+			replaced: -1,
+			rejected: true,
+		},
+		{
+			name:     "sid taken in another file collides",
+			value:    `alert tcp any any -> any 25 (msg:"smtp"; sid:6000; rev:2;)`,
+			replaced: -1,
+			rejected: true,
+		},
+		{
+			name:     "invalid rule",
+			value:    `alert tcp any any -> any (msg:"x";)`,
+			replaced: -1,
+			rejected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("snort_sid_min", test.min)
+			viper.Set("snort_sid_max", test.max)
+			defer viper.Set("snort_sid_min", 0)
+			defer viper.Set("snort_sid_max", 0)
+
+			index := snort.NewIndex()
+			for name, rules := range existing {
+				for _, rule := range rules {
+					if err := index.Add(name, rule); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			text, replaced, reason := placeSnort(test.value, file, existing[file], index)
+			if (reason != "") != test.rejected {
+				t.Fatalf("unexpected rejection %q", reason)
+			}
+			if text != test.text || replaced != test.replaced {
+				t.Errorf("got %q at %d, want %q at %d", text, replaced, test.text, test.replaced)
+			}
+		})
+	}
+}
+
+func TestMatchSnort(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		removal string
+		match   bool
+	}{
+		{
+			name:    "exact text",
+			line:    `alert tcp any any -> any 80 (msg:"web"; sid:5000; rev:1;)`,
+			removal: `alert tcp any any -> any 80 (msg:"web"; sid:5000; rev:1;)`,
+			match:   true,
+		},
+		{
+			name:    "sid",
+			line:    `alert tcp any any -> any 80 (msg:"web"; sid:5000; rev:1;)`,
+			removal: "sid:5000;",
+			match:   true,
+		},
+		{
+			name:    "other sid",
+			line:    `alert tcp any any -> any 80 (msg:"web"; sid:5000; rev:1;)`,
+			removal: "5001",
+		},
+		{
+			name:    "rule given a local sid is matched by its original text",
+			line:    `alert tcp any any -> any 80 (msg:"web"; sid:1000001; rev:1;)`,
+			removal: `alert tcp any any -> any 80 (msg:"web";)`,
+			match:   true,
+		},
+		{
+			name:    "rule without sid is matched regardless of list spacing",
+			line:    `alert tcp [10.0.0.1,10.0.0.2] any -> any 80 (msg:"web";)`,
+			removal: `alert tcp [10.0.0.1, 10.0.0.2] any -> any 80 (msg:"web";)`,
+			match:   true,
+		},
+		{
+			name:    "different rule",
+			line:    `alert tcp any any -> any 80 (msg:"web"; sid:1000001; rev:1;)`,
+			removal: `alert tcp any any -> any 443 (msg:"web";)`,
+		},
+		{
+			name:    "different text with the same sid is not matched by text",
+			line:    `alert tcp any any -> any 80 (msg:"web"; sid:5000; rev:2;)`,
+			removal: `alert tcp any any -> any 80 (msg:"web"; sid:5000; rev:1;)`,
+		},
+		{
+			name:    "comment",
+			line:    `# alert tcp any any -> any 80 (msg:"web";)`,
+			removal: `alert tcp any any -> any 80 (msg:"web";)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			removal, ok := matchSnort(test.line, parseSnortRemovals([]string{test.removal}))
+			if ok != test.match {
+				t.Fatalf("matched %t, want %t", ok, test.match)
+			}
+			if ok && removal != test.removal {
+				t.Errorf("matched removal %q, want %q", removal, test.removal)
+			}
+		})
+	}
+}
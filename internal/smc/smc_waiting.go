@@ -0,0 +1,214 @@
+package smc
+
+import (
+	"fmt"
+	"main/internal/batches"
+	"main/internal/state"
+	"main/internal/structs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// waitingSection and heldSection are the state store sections the batches waiting for an operator
+// and the paused lists are persisted in, so they wait again after a restart instead of failing.
+const (
+	waitingSection = "waiting_batches"
+	heldSection    = "held_lists"
+)
+
+// waitingBatch is a batch with changes held for approval or queued on a paused list. The whole
+// request is processed again after a restart, which is safe as additions already present are
+// skipped, and the changes the operator already decided on are not held again.
+type waitingBatch struct {
+	Request structs.Request `json:"request"`
+	// Decisions are the operator's decisions on the held changes of the batch, by change.
+	Decisions map[string]bool `json:"decisions,omitempty"`
+}
+
+// heldList is a list paused for changing faster than allowed.
+type heldList struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+var waiting = struct {
+	sync.Mutex
+	batches map[int]*waitingBatch
+	held    map[string]heldList
+}{batches: map[int]*waitingBatch{}, held: map[string]heldList{}}
+
+// LoadWaiting reads the batches which waited for an operator before the module was restarted. They
+// no longer count as interrupted, they are processed again once the workers start.
+func LoadWaiting() error {
+	waiting.Lock()
+	defer waiting.Unlock()
+
+	loaded := map[int]*waitingBatch{}
+	if err := state.Load(waitingSection, &loaded); err != nil {
+		return err
+	}
+	held := map[string]heldList{}
+	if err := state.Load(heldSection, &held); err != nil {
+		return err
+	}
+	waiting.batches = loaded
+	waiting.held = held
+
+	for id := range waiting.batches {
+		batches.Resume(id)
+	}
+	return nil
+}
+
+// restore pauses the lists which were held and processes the batches which waited for an operator
+// before the module was restarted, in the order they were received. Batches held back in the offline
+// buffer are replayed from there.
+func (p *pool) restore() {
+	waiting.Lock()
+	var requests []structs.Request
+	for id, w := range waiting.batches {
+		if record, ok := batches.Get(id); ok && record.State == batches.Deferred {
+			continue
+		}
+		requests = append(requests, w.Request)
+	}
+	held := map[string]heldList{}
+	for key, h := range waiting.held {
+		held[key] = h
+	}
+	waiting.Unlock()
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].BatchID < requests[j].BatchID
+	})
+
+	p.mu.Lock()
+	for key, h := range held {
+		p.lanes[key] = &lane{key: key, held: true, heldSince: h.Since, reason: h.Reason}
+	}
+	p.mu.Unlock()
+
+	for _, request := range requests {
+		logrus.Infof("batch %d waited for an operator when the module stopped and is restored", request.BatchID)
+		p.process(request, false)
+	}
+
+	// A paused list none of the restored batches changes has nothing left to release.
+	p.mu.Lock()
+	for key := range held {
+		if l := p.lanes[key]; l != nil && l.held && changeCount(l.jobs) == 0 {
+			delete(p.lanes, key)
+			forgetHold(key)
+		}
+	}
+	p.mu.Unlock()
+}
+
+// changeCount is the number of list jobs queued on a lane.
+func changeCount(jobs []job) int {
+	n := 0
+	for _, j := range jobs {
+		if j.check == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// rememberWaiting persists the request of a batch which waits for an operator. Dry runs and
+// rollbacks are not restored.
+func rememberWaiting(b *batch) {
+	if b.id == 0 || b.dryRun || b.rollback || b.plan != nil {
+		return
+	}
+
+	waiting.Lock()
+	defer waiting.Unlock()
+
+	if _, ok := waiting.batches[b.id]; ok {
+		return
+	}
+	waiting.batches[b.id] = &waitingBatch{Request: b.request}
+	saveWaiting()
+}
+
+// forgetWaiting drops a batch which finished.
+func forgetWaiting(id int) {
+	waiting.Lock()
+	defer waiting.Unlock()
+
+	if _, ok := waiting.batches[id]; !ok {
+		return
+	}
+	delete(waiting.batches, id)
+	saveWaiting()
+}
+
+// decisionKey identifies a held change within its batch.
+func decisionKey(approval structs.Approval) string {
+	return fmt.Sprintf("%s %s %s", approval.Operation, approval.List, approval.Value)
+}
+
+// recordDecision persists the operator's decision on a held change of a batch.
+func recordDecision(id int, approval structs.Approval, approved bool) {
+	waiting.Lock()
+	defer waiting.Unlock()
+
+	w, ok := waiting.batches[id]
+	if !ok {
+		return
+	}
+	if w.Decisions == nil {
+		w.Decisions = map[string]bool{}
+	}
+	w.Decisions[decisionKey(approval)] = approved
+	saveWaiting()
+}
+
+// decision returns the operator's decision on a held change made before a restart.
+func decision(id int, approval structs.Approval) (bool, bool) {
+	waiting.Lock()
+	defer waiting.Unlock()
+
+	w, ok := waiting.batches[id]
+	if !ok {
+		return false, false
+	}
+	approved, ok := w.Decisions[decisionKey(approval)]
+	return approved, ok
+}
+
+// rememberHold persists that a list is paused.
+func rememberHold(key, reason string, since time.Time) {
+	waiting.Lock()
+	defer waiting.Unlock()
+
+	waiting.held[key] = heldList{Reason: reason, Since: since}
+	saveHeld()
+}
+
+// forgetHold drops a list which was released or discarded.
+func forgetHold(key string) {
+	waiting.Lock()
+	defer waiting.Unlock()
+
+	if _, ok := waiting.held[key]; !ok {
+		return
+	}
+	delete(waiting.held, key)
+	saveHeld()
+}
+
+func saveWaiting() {
+	if err := state.Save(waitingSection, waiting.batches); err != nil {
+		logrus.Error("error persisting the batches waiting for an operator: ", err)
+	}
+}
+
+func saveHeld() {
+	if err := state.Save(heldSection, waiting.held); err != nil {
+		logrus.Error("error persisting the paused lists: ", err)
+	}
+}
@@ -0,0 +1,847 @@
+package smc
+
+import (
+	"main/internal/batches"
+	"main/internal/cache"
+	"main/internal/channel"
+	"main/internal/events"
+	"main/internal/provenance"
+	"main/internal/structs"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// snortLane is the lane all changes to the global snort configuration are serialized on.
+const snortLane = "snort"
+
+// Available reports whether there is an SMC session to process requests with.
+func Available() bool {
+	session := activeSession()
+	return session != nil && session.LoggedIn && !CircuitOpen()
+}
+
+// workers is the pool processing requests. It is started by StartWorkers before the server and
+// HandleRequests, so it is never replaced while they use it.
+var workers *pool
+
+// StartWorkers starts the pool processing requests. It must be called before the server starts.
+func StartWorkers() {
+	workers = newPool(viper.GetInt("worker_pool_size"))
+}
+
+// ErrNoSession is returned by jobs run while there is no SMC session.
+var ErrNoSession = errors.New("no SMC session is available")
+
+var active struct {
+	sync.RWMutex
+	session *Session
+}
+
+// setActiveSession replaces the session used by the workers.
+func setActiveSession(session *Session) {
+	active.Lock()
+	defer active.Unlock()
+
+	active.session = session
+}
+
+// activeSession returns the session used by the workers.
+func activeSession() *Session {
+	active.RLock()
+	defer active.RUnlock()
+
+	return active.session
+}
+
+// job is a unit of work against a single lane. Jobs on the same lane run one at a time in the
+// order they were submitted. List jobs carry their params so they can be coalesced, other jobs
+// provide a function to run and the items it changes.
+type job struct {
+	lane string
+	// name is the list or configuration the job changes, as shown in the batch history.
+	name    string
+	batches []*batch
+	params  *ListParams
+	items   []string
+	run     func(session *Session, report *listReport) error
+	// parts are the jobs merged into a coalesced job.
+	parts []job
+	// gate holds the job until an operator approves its change.
+	gate *gate
+	// commit records the outcome of the job once its change was written, it is not called for jobs
+	// which failed.
+	commit func(result batches.ListResult)
+	// check is the conflict check the job makes for a list job on another lane.
+	check *conflictCheck
+}
+
+// size is the number of items a job changes.
+func (j job) size() int {
+	if j.params == nil {
+		return 0
+	}
+	return len(j.params.items) + len(j.params.removals)
+}
+
+// changes returns every item the job changes.
+func (j job) changes() []string {
+	if j.params == nil {
+		return j.items
+	}
+	return append(j.params.items[:len(j.params.items):len(j.params.items)], j.params.removals...)
+}
+
+func (j job) execute() {
+	for _, part := range j.partsOrSelf() {
+		for _, b := range part.batches {
+			b.start()
+		}
+	}
+
+	report := newListReport(j.batchIDs())
+	err := j.apply(report)
+	if err != nil {
+		logrus.Error(err)
+	}
+	j.finish(report, err)
+}
+
+// partsOrSelf returns the jobs merged into a coalesced job, or the job itself.
+func (j job) partsOrSelf() []job {
+	if j.parts == nil {
+		return []job{j}
+	}
+	return j.parts
+}
+
+// batchIDs returns the IDs of the batches the job applies.
+func (j job) batchIDs() []int {
+	var ids []int
+	for _, part := range j.partsOrSelf() {
+		for _, b := range part.batches {
+			if b.id != 0 {
+				ids = append(ids, b.id)
+			}
+		}
+	}
+	return ids
+}
+
+// finish records the outcome of the job for each of its batches.
+func (j job) finish(report *listReport, err error) {
+	if j.check != nil {
+		workers.checkFinished(j.check, err)
+	}
+	for _, part := range j.partsOrSelf() {
+		result := report.listResult(part.name, part.changes(), err)
+		// List lanes are keyed by the list they change.
+		if part.lane != snortLane {
+			result.Key = part.lane
+		}
+		if err == nil && part.params != nil && !part.params.dryRun {
+			result.Released = releasedSources(part.params.releases, result)
+		}
+		if err == nil && part.commit != nil {
+			part.commit(result)
+		}
+		for _, b := range part.batches {
+			switch {
+			case b.plan != nil:
+				b.plan.add(part.lane, result, report.touchedFiles())
+			case b.id == 0:
+			case b.rollback:
+				batches.AddRollbackResult(b.id, result)
+			default:
+				batches.AddListResult(b.id, result)
+			}
+			events.Publish(events.Event{
+				Type:    events.ListCompleted,
+				Batches: b.ids(),
+				List:    result.List,
+				Message: result.Error,
+				Counts:  result.Counts,
+			})
+			b.done(err)
+		}
+	}
+}
+
+// releasedSources returns the sources whose references to the items of a list result were released,
+// as the items were removed or are kept for other sources.
+func releasedSources(releases map[string][]string, result batches.ListResult) map[string][]string {
+	if len(releases) == 0 {
+		return nil
+	}
+	released := map[string][]string{}
+	for _, item := range result.Items {
+		if item.Result != ItemRemoved && item.Result != ItemReferenced {
+			continue
+		}
+		for _, source := range releases[item.Value] {
+			released[source] = append(released[source], item.Value)
+		}
+	}
+	if len(released) == 0 {
+		return nil
+	}
+	return released
+}
+
+// apply makes the changes of the job, or plans them for dry runs, recording the results in report.
+func (j job) apply(report *listReport) error {
+	session := activeSession()
+	switch {
+	case session == nil:
+		return ErrNoSession
+	case j.params != nil:
+		params := *j.params
+		params.report = report
+		if err := session.adopt(params); err != nil {
+			return err
+		}
+		params = keepReferenced(params)
+		params, err := resolveConflicts(params)
+		if err != nil {
+			return err
+		}
+		if len(params.items) == 0 && len(params.removals) == 0 {
+			return nil
+		}
+		if params.dryRun {
+			return session.planList(params)
+		}
+		return session.UpdateLists(params)
+	default:
+		return j.run(session, report)
+	}
+}
+
+// coalesce merges consecutive list jobs into a single job for all of their batches. The last
+// operation on an item wins: an item added and later removed is removed, leaving keepReferenced to
+// decide whether it is kept, unless another source of the jobs still adds it.
+func coalesce(jobs []job) job {
+	if len(jobs) == 1 {
+		return jobs[0]
+	}
+
+	ops := map[string]structs.UpdateType{}
+	var order []string
+	apply := func(item string, op structs.UpdateType) {
+		if _, seen := ops[item]; !seen {
+			order = append(order, item)
+		}
+		ops[item] = op
+	}
+
+	// adders tracks the sources adding each item in the jobs taken so far. A removal releasing an
+	// item for some sources leaves it added while another of them still adds it, as applying the
+	// jobs one after another would.
+	adders := map[string]map[string]bool{}
+	merged := job{lane: jobs[0].lane, name: jobs[0].name, parts: jobs}
+	params := *jobs[0].params
+	params.items = nil
+	params.removals = nil
+	params.adders = nil
+	for _, j := range jobs {
+		for _, item := range j.params.items {
+			apply(item, structs.ADD)
+			if adders[item] == nil {
+				adders[item] = map[string]bool{}
+			}
+			for _, source := range j.params.adders[item] {
+				adders[item][source] = true
+			}
+		}
+		for _, item := range j.params.removals {
+			if sources, ok := j.params.releases[item]; ok && stillAdded(adders[item], sources) {
+				continue
+			}
+			apply(item, structs.DELETE)
+			delete(adders, item)
+		}
+	}
+
+	for _, item := range order {
+		switch ops[item] {
+		case structs.ADD:
+			params.items = append(params.items, item)
+		case structs.DELETE:
+			params.removals = append(params.removals, item)
+		}
+	}
+	params.conflicts = mergeConflictChecks(jobs)
+	params.releases = mergeReleases(jobs)
+	merged.params = &params
+
+	logrus.Infof("coalesced %d updates to list %s into %d additions and %d removals", len(jobs), params.list.name, len(params.items), len(params.removals))
+	return merged
+}
+
+// stillAdded returns whether a source other than the releasing ones adds an item.
+func stillAdded(adders map[string]bool, released []string) bool {
+	for source := range adders {
+		releasing := false
+		for _, r := range released {
+			if r == source {
+				releasing = true
+				break
+			}
+		}
+		if !releasing {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeReleases merges the sources coalesced jobs release their removals for. A value removed
+// regardless of its references by one of the jobs is removed regardless of them.
+func mergeReleases(jobs []job) map[string][]string {
+	releases := map[string][]string{}
+	unconditional := map[string]bool{}
+	for _, j := range jobs {
+		for _, value := range j.params.removals {
+			sources, ok := j.params.releases[value]
+			if !ok {
+				unconditional[value] = true
+			}
+			releases[value] = append(releases[value], sources...)
+		}
+	}
+	for value := range unconditional {
+		delete(releases, value)
+	}
+	return releases
+}
+
+// batch tracks the jobs of a request so a single status is reported once all of them finished.
+type batch struct {
+	mu        sync.Mutex
+	id        int
+	request   structs.Request
+	pending   int
+	started   bool
+	failed    bool
+	succeeded bool
+	// deferred is set when a job could not reach SMC, the request is then held back and replayed.
+	deferred bool
+	// queued is set for batches received through the request queue.
+	queued bool
+	// dryRun batches are planned, their status is only recorded in the batch history.
+	dryRun bool
+	// rollback batches revert the changes of an earlier batch with the same ID.
+	rollback bool
+	// awaiting is the number of changes of the batch waiting for an operator's approval.
+	awaiting int
+	// plan collects the results of batches planned for the plan endpoint.
+	plan *planCollector
+}
+
+// start records that the first job of the batch is running.
+func (b *batch) start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return
+	}
+	b.started = true
+	switch {
+	case b.id == 0:
+	case b.rollback:
+		batches.SetRollbackState(b.id, batches.Running)
+	// The batch stays pending until every held change is decided.
+	case b.awaiting > 0:
+	default:
+		batches.SetState(b.id, batches.Running)
+	}
+	events.Publish(events.Event{Type: events.Started, Batches: b.ids()})
+}
+
+// ids returns the batch ID as a list of event recipients.
+func (b *batch) ids() []int {
+	if b.id == 0 {
+		return nil
+	}
+	return []int{b.id}
+}
+
+// hold records that the batch waits for an operator decision.
+func (b *batch) hold() {
+	if b.id == 0 || b.rollback {
+		return
+	}
+	rememberWaiting(b)
+	batches.SetState(b.id, batches.Pending)
+}
+
+// await records that a change of the batch waits for an operator's approval.
+func (b *batch) await() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.awaiting++
+	// A batch waiting for an operator no longer takes up room in the request queue.
+	if b.queued {
+		b.queued = false
+		channel.Done()
+	}
+	if b.id != 0 {
+		rememberWaiting(b)
+		batches.SetState(b.id, batches.Pending)
+	}
+}
+
+// decide records the decision on a held change of the batch, which is made by the given number of
+// jobs.
+func (b *batch) decide(jobs int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.awaiting--
+	b.pending += jobs - 1
+	b.continued()
+}
+
+// resume records that the batch continues after an operator decision.
+func (b *batch) resume() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.continued()
+}
+
+// continued records the state of a batch which is no longer held. The batch lock must be held.
+func (b *batch) continued() {
+	// The batch may have finished in the meantime if the decision came late.
+	if b.id == 0 || b.rollback || b.pending == 0 || b.awaiting > 0 {
+		return
+	}
+	if b.started {
+		batches.SetState(b.id, batches.Running)
+	} else {
+		batches.SetState(b.id, batches.Queued)
+	}
+}
+
+func (b *batch) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending--
+	// Rollbacks are not held back, they are requested again once SMC can be reached. Plans are
+	// not held back either, their caller is waiting for them.
+	if errors.Cause(err) == ErrCircuitOpen && !b.rollback && b.plan == nil {
+		b.deferred = true
+	} else if err != nil {
+		b.failed = true
+	} else {
+		b.succeeded = true
+	}
+	if b.pending > 0 {
+		return
+	}
+
+	if b.queued {
+		channel.Done()
+	}
+	if b.plan != nil {
+		close(b.plan.finished)
+		return
+	}
+
+	// The whole request is replayed, which is safe as additions already present are skipped.
+	if b.deferred {
+		logrus.Infof("batch %d is held back until SMC can be reached", b.id)
+		bufferRequest(b.request)
+		if b.id != 0 {
+			batches.SetState(b.id, batches.Deferred)
+		}
+		events.Publish(events.Event{Type: events.Deferred, Batches: b.ids()})
+		// SMC may have been reached again while the batch finished, after the buffer was replayed.
+		if !CircuitOpen() && workers != nil {
+			go workers.replay()
+		}
+		return
+	}
+
+	status := structs.Success
+	if b.failed {
+		status = structs.Failed
+	}
+	// The controller only distinguishes success and failure, the history also records batches
+	// which were applied to some of their lists.
+	final := status
+	switch {
+	case b.failed && b.succeeded:
+		final = batches.Partial
+	case b.dryRun && !b.failed:
+		final = batches.Planned
+	}
+	switch {
+	case b.id == 0:
+	case b.rollback:
+		batches.SetRollbackState(b.id, final)
+		logrus.Infof("rollback of batch %d finished as %s", b.id, final)
+	default:
+		batches.SetState(b.id, final)
+		forgetWaiting(b.id)
+	}
+	event := events.Event{Type: events.Finished, Batches: b.ids(), Status: final}
+	if b.rollback {
+		event.Message = "rollback"
+	}
+	events.Publish(event)
+
+	// The controller is not told about dry runs, so the batch is still exported once it is
+	// submitted without the dry run.
+	if b.dryRun {
+		logrus.Infof("dry run of batch %d finished as %s", b.id, final)
+		return
+	}
+	// Batches without any jobs have not changed anything the policies use.
+	if b.succeeded && b.started {
+		refreshPolicies(b.id)
+	}
+	if session := activeSession(); session != nil {
+		go session.updateBatchStatus(b.id, status)
+	}
+}
+
+// lane is the queue of jobs for one list.
+type lane struct {
+	key  string
+	jobs []job
+	// items is the number of items changed by the queued jobs.
+	items int
+	// scheduled is set while the lane is ready or being worked on.
+	scheduled bool
+	// waiting is set while the lane is held back to collect more jobs for coalescing.
+	waiting bool
+	// held is set while the lane is paused for changing its list faster than allowed.
+	held      bool
+	heldSince time.Time
+	reason    string
+	// released is the number of changes an operator allowed to bypass the rate limit.
+	released int
+}
+
+// pool runs jobs on a fixed number of workers. Lanes are handed to one worker at a time, so
+// independent lists are processed in parallel while writes to the same list stay ordered.
+type pool struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	lanes map[string]*lane
+	ready []*lane
+	// intake orders requests being processed against those held back in the offline buffer.
+	intake sync.Mutex
+}
+
+func newPool(size int) *pool {
+	if size < 1 {
+		size = 1
+	}
+	p := &pool{lanes: map[string]*lane{}}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// dispatch admits a request and queues its jobs on their lanes. While SMC is unreachable, or older
+// requests are still held back, the request is held back in the offline buffer instead.
+func (p *pool) dispatch(request structs.Request) {
+	if viper.GetBool("dry_run") {
+		request.DryRun = true
+	}
+	if !admit(request) {
+		channel.Done()
+		return
+	}
+
+	p.intake.Lock()
+	defer p.intake.Unlock()
+
+	if CircuitOpen() || Buffered() > 0 {
+		bufferRequest(request)
+		if request.BatchID != 0 {
+			batches.SetState(request.BatchID, batches.Deferred)
+		}
+		events.Publish(events.Event{Type: events.Deferred, Batches: requestIDs(request)})
+		channel.Done()
+		// Requests left in the buffer once SMC was reached again are replayed along with this one.
+		if !CircuitOpen() {
+			go p.replay()
+		}
+		return
+	}
+	events.Publish(events.Event{Type: events.Queued, Batches: requestIDs(request)})
+	p.process(request, true)
+}
+
+// process splits a request into jobs and queues them on their lanes.
+func (p *pool) process(request structs.Request, queued bool) {
+	jobs := requestJobs(request)
+	b := &batch{id: request.BatchID, request: request, pending: len(jobs), queued: queued, dryRun: request.DryRun}
+	if len(jobs) == 0 {
+		b.pending = 1
+		b.done(nil)
+		return
+	}
+
+	// Held changes are queued first, so the batch is pending before any of its jobs start. Changes
+	// the operator decided on before a restart are decided again right away.
+	for i := range jobs {
+		jobs[i].batches = []*batch{b}
+		if jobs[i].gate == nil {
+			continue
+		}
+		queueApproval(jobs[i])
+		if approved, ok := decision(b.id, jobs[i].gate.approval); ok {
+			if err := DecideApproval(jobs[i].gate.approval.ID, approved); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}
+	for _, j := range jobs {
+		if j.gate == nil {
+			p.submit(j)
+		}
+	}
+}
+
+// replay processes the requests held back in the offline buffer in the order they were received.
+func (p *pool) replay() {
+	p.intake.Lock()
+	defer p.intake.Unlock()
+
+	replayed := 0
+	for !CircuitOpen() {
+		request, ok := unbufferRequest()
+		if !ok {
+			break
+		}
+		events.Publish(events.Event{
+			Type:    events.Retry,
+			Batches: requestIDs(request),
+			Message: "replaying the batch held back while SMC was unreachable",
+		})
+		p.process(request, false)
+		replayed++
+	}
+	if replayed > 0 {
+		logrus.Infof("replayed %d requests held back while SMC was unreachable", replayed)
+	}
+}
+
+// requestIDs returns the batch ID of a request as a list of event recipients.
+func requestIDs(request structs.Request) []int {
+	if request.BatchID == 0 {
+		return nil
+	}
+	return []int{request.BatchID}
+}
+
+// admit checks a request against the batch history so a batch which is submitted again is not
+// applied twice. The stored status of a finished duplicate is reported again, a duplicate of a batch
+// which is still in progress is dropped as its status will be reported once it finishes. A batch
+// resubmitted with different content while it is still in progress is dropped as well.
+func admit(request structs.Request) bool {
+	if request.BatchID == 0 {
+		return true
+	}
+
+	record, outcome := batches.Register(request)
+	switch outcome {
+	case batches.Duplicate:
+		logrus.Infof("batch %d has already been submitted and is %s", record.ID, record.State)
+		if session := activeSession(); session != nil && record.Done() {
+			go session.updateBatchStatus(record.ID, record.State)
+		}
+		return false
+	case batches.InFlight:
+		logrus.Warnf("batch %d has been resubmitted with different content while it is %s and is refused", record.ID, record.State)
+		return false
+	case batches.Conflict:
+		logrus.Warnf("batch %d has been resubmitted with different content and is processed again", record.ID)
+	}
+	return true
+}
+
+// submit queues a job at the end of its lane. A list job arriving at an idle lane holds the lane
+// back for the coalescing window, unless enough items are already queued. The conflict check of a
+// list job is queued on the lane of the opposite list at the same time.
+func (p *pool) submit(j job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if j.params != nil && j.params.conflicts != nil {
+		p.enqueue(j.params.conflicts.job())
+	}
+	p.enqueue(j)
+}
+
+// enqueue queues a job at the end of its lane. The pool lock must be held.
+func (p *pool) enqueue(j job) {
+	l, ok := p.lanes[j.lane]
+	if !ok {
+		l = &lane{key: j.lane}
+		p.lanes[j.lane] = l
+	}
+	if l.held {
+		if j.check != nil {
+			p.checkHeld(l, j)
+			return
+		}
+		l.jobs = append(l.jobs, j)
+		l.items += j.size()
+		j.hold()
+		return
+	}
+	l.jobs = append(l.jobs, j)
+	l.items += j.size()
+	if l.scheduled {
+		return
+	}
+
+	window := time.Duration(viper.GetInt("coalesce_window_ms")) * time.Millisecond
+	if window > 0 && j.params != nil && l.items < viper.GetInt("coalesce_max_items") {
+		if !l.waiting {
+			l.waiting = true
+			time.AfterFunc(window, func() {
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				if l.waiting {
+					p.schedule(l)
+				}
+			})
+		}
+		return
+	}
+	p.schedule(l)
+}
+
+// schedule hands a lane to the workers. The pool lock must be held.
+func (p *pool) schedule(l *lane) {
+	l.waiting = false
+	l.scheduled = true
+	p.ready = append(p.ready, l)
+	p.cond.Signal()
+}
+
+func (p *pool) work() {
+	for {
+		p.mu.Lock()
+		for len(p.ready) == 0 {
+			p.cond.Wait()
+		}
+		l := p.ready[0]
+		p.ready = p.ready[1:]
+
+		// A list job waits for the check of the opposite list, the lane is scheduled again once the
+		// check finished.
+		// Only the conflict checks queued ahead of the held jobs run while a lane is held.
+		if !l.jobs[0].checked() || l.held && l.jobs[0].check == nil {
+			l.scheduled = false
+			p.mu.Unlock()
+			continue
+		}
+
+		// Take every consecutive list job at the front of the lane, up to the item threshold. Dry
+		// runs are only coalesced with each other.
+		n := 1
+		items := l.jobs[0].size()
+		if l.jobs[0].params != nil {
+			for n < len(l.jobs) && l.jobs[n].params != nil && l.jobs[n].params.dryRun == l.jobs[0].params.dryRun &&
+				l.jobs[n].checked() && items+l.jobs[n].size() <= viper.GetInt("coalesce_max_items") {
+				items += l.jobs[n].size()
+				n++
+			}
+		}
+
+		// Hold the lane back if the jobs would change the list faster than allowed.
+		// The alert is raised through the log hook once the pool lock is released.
+		if reason := p.limit(l, l.jobs[:n]); reason != "" {
+			alert := p.hold(l, reason)
+			p.mu.Unlock()
+			logrus.Error(alert)
+			continue
+		}
+		taken := l.jobs[:n:n]
+		l.jobs = l.jobs[n:]
+		l.items -= items
+		p.mu.Unlock()
+
+		if taken[0].params != nil {
+			coalesce(taken).execute()
+		} else {
+			taken[0].execute()
+		}
+
+		p.mu.Lock()
+		if len(l.jobs) > 0 {
+			p.schedule(l)
+		} else {
+			l.scheduled = false
+			delete(p.lanes, l.key)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// reportForeignEntries warns about the entries of a list which were not added by the module, if
+// enabled.
+func reportForeignEntries(key, name string, entries []string) {
+	if !viper.GetBool("report_foreign_entries") {
+		return
+	}
+	if foreign := len(entries) - len(provenance.Owned(key, entries...)); foreign > 0 {
+		logrus.Warnf("list %s contains %d entries which were not added by the module", name, foreign)
+	}
+}
+
+// verifyLists queues the verification of every stored list on the list's lane, so a list is never
+// recovered or renamed while it is being written to.
+func (p *pool) verifyLists() {
+	for key, ref := range storedLists() {
+		key := key
+		p.submit(job{
+			lane: key,
+			name: ref.Name,
+			run: func(session *Session, report *listReport) error {
+				session.verifyList(key)
+				return nil
+			},
+		})
+	}
+}
+
+// refreshListCache queues a refresh of every cached list on the list's lane, so a refresh is never
+// interleaved with a write to the same list.
+func (p *pool) refreshListCache() {
+	types := cache.Types()
+	for key, ref := range storedLists() {
+		listType, ok := types[ref.ID]
+		if !ok {
+			continue
+		}
+		id, key, name := ref.ID, key, ref.Name
+		p.submit(job{
+			lane: key,
+			name: ref.Name,
+			run: func(session *Session, report *listReport) error {
+				list, err := session.refreshList(listType, id)
+				if err != nil {
+					return errors.Wrap(err, "error refreshing the cached list "+id)
+				}
+				reportForeignEntries(key, name, list.Entries)
+				return nil
+			},
+		})
+	}
+}
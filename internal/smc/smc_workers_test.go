@@ -0,0 +1,119 @@
+package smc
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	list := managedList{key: "blocklist", name: "blocklist"}
+	add := func(source string, items ...string) job {
+		return additionJob(ListParams{items: items, list: list, dryRun: true}, map[string][]string{source: items}, 0)
+	}
+	remove := func(source, item string) job {
+		return removalJob(ListParams{removals: []string{item}, list: list, dryRun: true}, source)
+	}
+	unconditional := func(items ...string) job {
+		return job{params: &ListParams{removals: items, list: list, dryRun: true}}
+	}
+
+	tests := []struct {
+		name     string
+		jobs     []job
+		items    []string
+		removals []string
+	}{
+		{
+			name:  "additions are merged in order",
+			jobs:  []job{add("a", "1.1.1.1", "2.2.2.2"), add("b", "3.3.3.3", "1.1.1.1")},
+			items: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+		},
+		{
+			name:     "removal after an addition of the same source wins",
+			jobs:     []job{add("a", "1.1.1.1"), remove("a", "1.1.1.1")},
+			removals: []string{"1.1.1.1"},
+		},
+		{
+			name:  "removal after an addition of another source keeps the addition",
+			jobs:  []job{add("a", "1.1.1.1"), remove("b", "1.1.1.1")},
+			items: []string{"1.1.1.1"},
+		},
+		{
+			name:     "unconditional removal after an addition wins",
+			jobs:     []job{add("a", "1.1.1.1"), unconditional("1.1.1.1")},
+			removals: []string{"1.1.1.1"},
+		},
+		{
+			name:  "addition after a removal wins",
+			jobs:  []job{remove("a", "1.1.1.1"), add("a", "1.1.1.1")},
+			items: []string{"1.1.1.1"},
+		},
+		{
+			name:     "removal after a removed addition of another source wins",
+			jobs:     []job{add("a", "1.1.1.1"), remove("a", "1.1.1.1"), remove("b", "1.1.1.1")},
+			removals: []string{"1.1.1.1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params := coalesce(test.jobs).params
+			if !reflect.DeepEqual(params.items, test.items) || !reflect.DeepEqual(params.removals, test.removals) {
+				t.Errorf("got additions %v and removals %v, want %v and %v", params.items, params.removals, test.items, test.removals)
+			}
+		})
+	}
+}
+
+func TestPoolSerializesLanes(t *testing.T) {
+	setActiveSession(&Session{})
+	defer setActiveSession(nil)
+
+	p := newPool(4)
+	const perLane = 20
+	lanes := []string{"a", "b", "c"}
+
+	var mu sync.Mutex
+	order := map[string][]int{}
+	running := map[string]int{}
+	overlapped := false
+	var wg sync.WaitGroup
+
+	for i := 0; i < perLane; i++ {
+		for _, lane := range lanes {
+			i, lane := i, lane
+			wg.Add(1)
+			p.submit(job{lane: lane, name: lane, run: func(*Session, *listReport) error {
+				defer wg.Done()
+				mu.Lock()
+				running[lane]++
+				if running[lane] > 1 {
+					overlapped = true
+				}
+				order[lane] = append(order[lane], i)
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				running[lane]--
+				mu.Unlock()
+				return nil
+			}})
+		}
+	}
+	wg.Wait()
+
+	if overlapped {
+		t.Error("jobs of the same lane ran at the same time")
+	}
+	for _, lane := range lanes {
+		for i, n := range order[lane] {
+			if n != i {
+				t.Fatalf("jobs of lane %s ran in the order %v", lane, order[lane])
+			}
+		}
+	}
+}
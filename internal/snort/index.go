@@ -0,0 +1,46 @@
+package snort
+
+// Indexed is a rule of a snort configuration with the file it is in.
+type Indexed struct {
+	File string
+	Text string
+	Rule *Rule
+}
+
+// Index is the set of rules of a snort configuration by signature ID.
+type Index struct {
+	rules map[int]Indexed
+}
+
+func NewIndex() *Index {
+	return &Index{rules: map[int]Indexed{}}
+}
+
+// Add indexes a rule of a file. Rules without a signature ID are not indexed, rules which cannot be
+// parsed are not indexed either and their error is returned.
+func (i *Index) Add(file, text string) error {
+	rule, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	if rule.SID() != 0 {
+		i.rules[rule.SID()] = Indexed{File: file, Text: text, Rule: rule}
+	}
+	return nil
+}
+
+// Lookup returns the rule with a signature ID.
+func (i *Index) Lookup(sid int) (Indexed, bool) {
+	indexed, ok := i.rules[sid]
+	return indexed, ok
+}
+
+// NextSID returns the lowest signature ID from min to max which no rule uses.
+func (i *Index) NextSID(min, max int) (int, bool) {
+	for sid := min; sid <= max; sid++ {
+		if _, ok := i.rules[sid]; !ok {
+			return sid, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,71 @@
+package snort
+
+import "testing"
+
+func TestIndexAdd(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		sid     int
+		invalid bool
+	}{
+		{name: "rule with sid", text: `alert tcp any any -> any 80 (msg:"x"; sid:10;)`, sid: 10},
+		{name: "rule without sid", text: `alert tcp any any -> any 80 (msg:"x";)`},
+		{name: "rule with spaced list", text: `alert tcp [10.0.0.1, 10.0.0.2] any -> any 80 (sid:11;)`, sid: 11},
+		{name: "unparseable rule", text: `alert tcp any any -> any 80 (sid:12`, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index := NewIndex()
+			err := index.Add("local.config", test.text)
+			if (err != nil) != test.invalid {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.sid == 0 {
+				if len(index.rules) != 0 {
+					t.Errorf("expected no indexed rule, found %d", len(index.rules))
+				}
+				return
+			}
+			indexed, ok := index.Lookup(test.sid)
+			if !ok {
+				t.Fatalf("sid %d is not indexed", test.sid)
+			}
+			if indexed.File != "local.config" || indexed.Text != test.text {
+				t.Errorf("indexed %+v", indexed)
+			}
+		})
+	}
+}
+
+func TestIndexNextSID(t *testing.T) {
+	tests := []struct {
+		name     string
+		taken    []int
+		min, max int
+		want     int
+		ok       bool
+	}{
+		{name: "empty range start", min: 100, max: 102, want: 100, ok: true},
+		{name: "skips taken", taken: []int{100, 101}, min: 100, max: 102, want: 102, ok: true},
+		{name: "fills gaps", taken: []int{100, 102}, min: 100, max: 102, want: 101, ok: true},
+		{name: "ignores sids outside the range", taken: []int{99, 103}, min: 100, max: 102, want: 100, ok: true},
+		{name: "exhausted", taken: []int{100, 101, 102}, min: 100, max: 102},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index := NewIndex()
+			for _, sid := range test.taken {
+				rule := &Rule{}
+				rule.SetSID(sid)
+				index.rules[sid] = Indexed{Rule: rule}
+			}
+			sid, ok := index.NextSID(test.min, test.max)
+			if sid != test.want || ok != test.ok {
+				t.Errorf("got %d %t, want %d %t", sid, ok, test.want, test.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package snort
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+var actions = map[string]bool{
+	"alert": true, "log": true, "pass": true, "activate": true, "dynamic": true,
+	"drop": true, "reject": true, "sdrop": true,
+}
+
+var protocols = map[string]bool{"tcp": true, "udp": true, "icmp": true, "ip": true}
+
+// optionName matches the name of a rule option.
+var optionName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.]*$`)
+
+// variable matches a reference to a snort variable.
+var variable = regexp.MustCompile(`^\$[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Option is a single option of a rule, such as msg or sid.
+type Option struct {
+	Name     string
+	Value    string
+	HasValue bool
+}
+
+// Rule is a parsed snort rule.
+type Rule struct {
+	Action           string
+	Protocol         string
+	Source           string
+	SourcePorts      string
+	Direction        string
+	Destination      string
+	DestinationPorts string
+	Options          []Option
+}
+
+// Parse validates the header and option syntax of a rule.
+func Parse(text string) (*Rule, error) {
+	text = strings.TrimSpace(text)
+	open := strings.Index(text, "(")
+	if open < 0 || !strings.HasSuffix(text, ")") {
+		return nil, errors.New("the rule options must be enclosed in parentheses")
+	}
+
+	header, err := headerFields(text[:open])
+	if err != nil {
+		return nil, err
+	}
+	if len(header) != 7 {
+		return nil, errors.New(fmt.Sprintf("the rule header must have 7 fields, found %d", len(header)))
+	}
+	r := &Rule{
+		Action:           header[0],
+		Protocol:         header[1],
+		Source:           header[2],
+		SourcePorts:      header[3],
+		Direction:        header[4],
+		Destination:      header[5],
+		DestinationPorts: header[6],
+	}
+	if !actions[r.Action] {
+		return nil, errors.New(fmt.Sprintf("unknown rule action %s", r.Action))
+	}
+	if !protocols[strings.ToLower(r.Protocol)] {
+		return nil, errors.New(fmt.Sprintf("unknown protocol %s", r.Protocol))
+	}
+	if r.Direction != "->" && r.Direction != "<>" {
+		return nil, errors.New(fmt.Sprintf("unknown direction operator %s", r.Direction))
+	}
+	for _, address := range []string{r.Source, r.Destination} {
+		if !validList(address, validAddress) {
+			return nil, errors.New(fmt.Sprintf("invalid address %s", address))
+		}
+	}
+	for _, ports := range []string{r.SourcePorts, r.DestinationPorts} {
+		if !validList(ports, validPort) {
+			return nil, errors.New(fmt.Sprintf("invalid port %s", ports))
+		}
+	}
+
+	options, err := parseOptions(text[open+1 : len(text)-1])
+	if err != nil {
+		return nil, err
+	}
+	r.Options = options
+
+	seen := map[string]bool{}
+	for _, option := range options {
+		switch option.Name {
+		case "sid", "rev":
+			if seen[option.Name] {
+				return nil, errors.New(fmt.Sprintf("the %s option is given more than once", option.Name))
+			}
+			seen[option.Name] = true
+			if n, err := strconv.Atoi(option.Value); err != nil || n < 1 {
+				return nil, errors.New(fmt.Sprintf("the %s option must be a positive number", option.Name))
+			}
+		}
+	}
+	return r, nil
+}
+
+// headerFields splits the header of a rule on the whitespace outside of bracketed lists. Whitespace
+// within the lists is dropped, so lists are rendered the same however they were spaced.
+func headerFields(header string) ([]string, error) {
+	var fields []string
+	var current strings.Builder
+	depth := 0
+	for _, c := range header {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("a bracketed list in the rule header is closed but was not opened")
+			}
+		case unicode.IsSpace(c):
+			if depth == 0 && current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(c)
+	}
+	if depth > 0 {
+		return nil, errors.New("a bracketed list in the rule header is not closed")
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields, nil
+}
+
+// parseOptions splits the option body of a rule on the semicolons outside of quoted strings.
+func parseOptions(body string) ([]Option, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, nil
+	}
+	if !strings.HasSuffix(body, ";") {
+		return nil, errors.New("the last rule option must end with a semicolon")
+	}
+
+	var options []Option
+	var current strings.Builder
+	quoted, escaped := false, false
+	for _, c := range body {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ';' && !quoted:
+			option, err := parseOption(current.String())
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, option)
+			current.Reset()
+			continue
+		}
+		current.WriteRune(c)
+	}
+	if quoted {
+		return nil, errors.New("a quoted option value is not closed")
+	}
+	return options, nil
+}
+
+func parseOption(text string) (Option, error) {
+	text = strings.TrimSpace(text)
+	option := Option{Name: text}
+	if i := strings.Index(text, ":"); i >= 0 {
+		option = Option{Name: strings.TrimSpace(text[:i]), Value: strings.TrimSpace(text[i+1:]), HasValue: true}
+	}
+	if !optionName.MatchString(option.Name) {
+		return Option{}, errors.New(fmt.Sprintf("invalid rule option %q", text))
+	}
+	option.Name = strings.ToLower(option.Name)
+	return option, nil
+}
+
+// validList checks a header field, which may be negated or a bracketed list of values.
+func validList(value string, valid func(string) bool) bool {
+	value = strings.TrimPrefix(value, "!")
+	if value == "any" || variable.MatchString(value) {
+		return true
+	}
+	if strings.HasPrefix(value, "[") {
+		if !strings.HasSuffix(value, "]") || len(value) < 3 {
+			return false
+		}
+		for _, item := range splitList(value[1 : len(value)-1]) {
+			if !validList(item, valid) {
+				return false
+			}
+		}
+		return true
+	}
+	return valid(value)
+}
+
+// splitList splits the values of a bracketed list on the commas outside of nested lists.
+func splitList(list string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, list[start:])
+}
+
+func validAddress(value string) bool {
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return true
+	}
+	return net.ParseIP(value) != nil
+}
+
+// validPort checks a port or a range of ports, either bound of which may be left open.
+func validPort(value string) bool {
+	bounds := strings.Split(value, ":")
+	if len(bounds) > 2 || value == ":" {
+		return false
+	}
+	for _, bound := range bounds {
+		if bound == "" && len(bounds) == 2 {
+			continue
+		}
+		if port, err := strconv.Atoi(bound); err != nil || port < 0 || port > 65535 {
+			return false
+		}
+	}
+	return true
+}
+
+// SID returns the signature ID of the rule, or 0 if it has none.
+func (r *Rule) SID() int {
+	return r.number("sid")
+}
+
+// Rev returns the revision of the rule, or 0 if it has none.
+func (r *Rule) Rev() int {
+	return r.number("rev")
+}
+
+func (r *Rule) number(name string) int {
+	for _, option := range r.Options {
+		if option.Name == name {
+			n, _ := strconv.Atoi(option.Value)
+			return n
+		}
+	}
+	return 0
+}
+
+// SetSID gives the rule a signature ID, and the first revision if it has none.
+func (r *Rule) SetSID(sid int) {
+	r.set("sid", strconv.Itoa(sid))
+	if r.Rev() == 0 {
+		r.set("rev", "1")
+	}
+}
+
+func (r *Rule) set(name, value string) {
+	for i := range r.Options {
+		if r.Options[i].Name == name {
+			r.Options[i].Value = value
+			return
+		}
+	}
+	r.Options = append(r.Options, Option{Name: name, Value: value, HasValue: true})
+}
+
+// String renders the rule in snort syntax.
+func (r *Rule) String() string {
+	return r.render(nil)
+}
+
+// Signature renders the rule without its signature ID and revision, so the same rule is recognised
+// whichever IDs it was given.
+func (r *Rule) Signature() string {
+	return r.render(map[string]bool{"sid": true, "rev": true})
+}
+
+func (r *Rule) render(skip map[string]bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s %s %s %s %s (", r.Action, r.Protocol, r.Source, r.SourcePorts, r.Direction, r.Destination, r.DestinationPorts)
+	first := true
+	for _, option := range r.Options {
+		if skip[option.Name] {
+			continue
+		}
+		if !first {
+			b.WriteString(" ")
+		}
+		first = false
+		b.WriteString(option.Name)
+		if option.HasValue {
+			b.WriteString(":" + option.Value)
+		}
+		b.WriteString(";")
+	}
+	b.WriteString(")")
+	return b.String()
+}
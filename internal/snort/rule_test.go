@@ -0,0 +1,149 @@
+package snort
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		// rendered is the expected rendering of the rule, empty when parsing fails.
+		rendered string
+	}{
+		{
+			name:     "simple rule",
+			text:     `alert tcp any any -> 10.0.0.0/8 80 (msg:"test"; sid:1000001; rev:2;)`,
+			rendered: `alert tcp any any -> 10.0.0.0/8 80 (msg:"test"; sid:1000001; rev:2;)`,
+		},
+		{
+			name:     "bracketed lists with spaces",
+			text:     `alert tcp [10.0.0.1, 10.0.0.2] any -> any [80, 443] (msg:"lists"; sid:1;)`,
+			rendered: `alert tcp [10.0.0.1,10.0.0.2] any -> any [80,443] (msg:"lists"; sid:1;)`,
+		},
+		{
+			name:     "nested and negated lists",
+			text:     `drop ip [10.0.0.0/8, ![10.1.0.0/16, 10.2.0.0/16]] any <> $HOME_NET !22 (msg:"nested";)`,
+			rendered: `drop ip [10.0.0.0/8,![10.1.0.0/16,10.2.0.0/16]] any <> $HOME_NET !22 (msg:"nested";)`,
+		},
+		{
+			name:     "port ranges",
+			text:     `alert udp any 1024: -> any :1023 (msg:"ports";)`,
+			rendered: `alert udp any 1024: -> any :1023 (msg:"ports";)`,
+		},
+		{
+			name:     "semicolon in quoted value",
+			text:     `alert tcp any any -> any any (msg:"a; b"; content:"\"x\";";)`,
+			rendered: `alert tcp any any -> any any (msg:"a; b"; content:"\"x\";";)`,
+		},
+		{name: "missing options", text: `alert tcp any any -> any any`},
+		{name: "missing header field", text: `alert tcp any -> any any (msg:"x";)`},
+		{name: "unknown action", text: `warn tcp any any -> any any (msg:"x";)`},
+		{name: "unknown protocol", text: `alert sctp any any -> any any (msg:"x";)`},
+		{name: "unknown direction", text: `alert tcp any any <- any any (msg:"x";)`},
+		{name: "invalid address", text: `alert tcp 10.0.0.300 any -> any any (msg:"x";)`},
+		{name: "invalid port", text: `alert tcp any 70000 -> any any (msg:"x";)`},
+		{name: "invalid address in list", text: `alert tcp [10.0.0.1, nope] any -> any any (msg:"x";)`},
+		{name: "unclosed list", text: `alert tcp [10.0.0.1, 10.0.0.2 any -> any any (msg:"x";)`},
+		{name: "unopened list", text: `alert tcp 10.0.0.1] any -> any any (msg:"x";)`},
+		{name: "unterminated option", text: `alert tcp any any -> any any (msg:"x")`},
+		{name: "unclosed quote", text: `alert tcp any any -> any any (msg:"x;)`},
+		{name: "duplicate sid", text: `alert tcp any any -> any any (sid:1; sid:2;)`},
+		{name: "non numeric rev", text: `alert tcp any any -> any any (sid:1; rev:a;)`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := Parse(test.text)
+			if test.rendered == "" {
+				if err == nil {
+					t.Fatalf("expected an error, parsed %q", rule.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rendered := rule.String(); rendered != test.rendered {
+				t.Errorf("rendered %q, want %q", rendered, test.rendered)
+			}
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{
+			name:  "sid and rev are ignored",
+			a:     `alert tcp any any -> any 80 (msg:"x"; sid:1000001; rev:3;)`,
+			b:     `alert tcp any any -> any 80 (msg:"x";)`,
+			equal: true,
+		},
+		{
+			name:  "list spacing is ignored",
+			a:     `alert tcp [10.0.0.1, 10.0.0.2] any -> any 80 (msg:"x";)`,
+			b:     `alert tcp [10.0.0.1,10.0.0.2] any -> any 80 (msg:"x"; sid:5;)`,
+			equal: true,
+		},
+		{
+			name: "options differ",
+			a:    `alert tcp any any -> any 80 (msg:"x"; sid:1;)`,
+			b:    `alert tcp any any -> any 80 (msg:"y"; sid:1;)`,
+		},
+		{
+			name: "header differs",
+			a:    `alert tcp any any -> any 80 (msg:"x";)`,
+			b:    `alert tcp any any -> any 443 (msg:"x";)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := Parse(test.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := Parse(test.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if equal := a.Signature() == b.Signature(); equal != test.equal {
+				t.Errorf("signatures %q and %q equal: %t, want %t", a.Signature(), b.Signature(), equal, test.equal)
+			}
+		})
+	}
+}
+
+func TestSetSID(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "rule without sid is given the first revision",
+			text: `alert tcp any any -> any 80 (msg:"x";)`,
+			want: `alert tcp any any -> any 80 (msg:"x"; sid:1000001; rev:1;)`,
+		},
+		{
+			name: "revision is kept",
+			text: `alert tcp any any -> any 80 (msg:"x"; sid:7; rev:4;)`,
+			want: `alert tcp any any -> any 80 (msg:"x"; sid:1000001; rev:4;)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := Parse(test.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule.SetSID(1000001)
+			if got := rule.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
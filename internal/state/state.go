@@ -0,0 +1,179 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Version is the current schema version of the state documents.
+const Version = 2
+
+// Migration converts the data of a section from the version it is registered for to the next one.
+type Migration func(data json.RawMessage) (json.RawMessage, error)
+
+// document is the on-disk representation of a section.
+type document struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+var (
+	mu         sync.Mutex
+	dir        string
+	migrations = map[string]map[int]Migration{}
+)
+
+// Init sets the directory the state is stored in, creating it if required. The state is kept apart
+// from config.yml so runtime changes neither trigger a config reload nor get lost on a config restore.
+func Init(stateDir string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(stateDir, os.ModePerm); err != nil {
+		return errors.Wrap(err, "error creating the state directory")
+	}
+	dir = stateDir
+	return nil
+}
+
+// RegisterMigration registers the conversion of a section's data from the given version to the next.
+func RegisterMigration(section string, from int, migration Migration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if migrations[section] == nil {
+		migrations[section] = map[int]Migration{}
+	}
+	migrations[section][from] = migration
+}
+
+// Load decodes a section into v, migrating it to the current version first. v is left untouched if
+// the section has not been saved yet.
+func Load(section string, v interface{}) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if dir == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(sectionPath(section))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading state section %s", section))
+	}
+
+	doc := document{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error decoding state section %s", section))
+	}
+
+	if doc.Version > Version {
+		return errors.New(fmt.Sprintf("state section %s has version %d which is newer than the supported version %d", section, doc.Version, Version))
+	}
+
+	for ; doc.Version < Version; doc.Version++ {
+		migration, ok := migrations[section][doc.Version]
+		if !ok {
+			continue
+		}
+		if doc.Data, err = migration(doc.Data); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error migrating state section %s from version %d", section, doc.Version))
+		}
+	}
+
+	if len(doc.Data) == 0 || string(doc.Data) == "null" {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(doc.Data, v), fmt.Sprintf("error decoding state section %s", section))
+}
+
+// Save encodes v as a section. The section is written to a temporary file which then replaces the
+// previous version, so a crash never leaves a partially written section behind.
+func Save(section string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error encoding state section %s", section))
+	}
+	raw, err := json.Marshal(document{Version: Version, Data: data})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error encoding state section %s", section))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if dir == "" {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(dir, section+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error creating state section %s", section))
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, fmt.Sprintf("error writing state section %s", section))
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, fmt.Sprintf("error syncing state section %s", section))
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error closing state section %s", section))
+	}
+
+	if err := os.Rename(tmp.Name(), sectionPath(section)); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error replacing state section %s", section))
+	}
+	return nil
+}
+
+func sectionPath(section string) string {
+	return filepath.Join(dir, section+".json")
+}
+
+// Sections returns the names of the saved sections starting with prefix.
+func Sections(prefix string) ([]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if dir == "" {
+		return nil, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, prefix+"*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing the state sections")
+	}
+	sections := make([]string, len(paths))
+	for i, path := range paths {
+		sections[i] = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+	return sections, nil
+}
+
+// Delete removes a section. Deleting a section which was never saved is not an error.
+func Delete(section string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if dir == "" {
+		return nil
+	}
+	if err := os.Remove(sectionPath(section)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, fmt.Sprintf("error removing state section %s", section))
+	}
+	return nil
+}
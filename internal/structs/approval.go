@@ -0,0 +1,25 @@
+package structs
+
+import "time"
+
+// Approval is a change to a single list item which waits for an operator's decision.
+type Approval struct {
+	ID        int        `json:"id"`
+	BatchID   int        `json:"batch_id,omitempty"`
+	List      string     `json:"list"`
+	Name      string     `json:"name"`
+	Value     string     `json:"value"`
+	Operation UpdateType `json:"operation"`
+	Reason    string     `json:"reason"`
+	Requested time.Time  `json:"requested"`
+}
+
+// ApprovalDecision approves or rejects a held change.
+type ApprovalDecision struct {
+	Action string `json:"action"`
+}
+
+const (
+	Approve = "approve"
+	Reject  = "reject"
+)
@@ -0,0 +1,9 @@
+package structs
+
+type Health struct {
+	SMCAvailable     bool `json:"smc_available"`
+	CircuitOpen      bool `json:"circuit_open"`
+	QueueDepth       int  `json:"queue_depth"`
+	QueueCapacity    int  `json:"queue_capacity"`
+	BufferedRequests int  `json:"buffered_requests"`
+}
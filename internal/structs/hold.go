@@ -0,0 +1,25 @@
+package structs
+
+import "time"
+
+// Hold is a list whose changes are paused for exceeding the configured rate of change.
+type Hold struct {
+	List     string    `json:"list"`
+	Name     string    `json:"name"`
+	Reason   string    `json:"reason"`
+	Since    time.Time `json:"since"`
+	Adds     int       `json:"adds"`
+	Removals int       `json:"removals"`
+	Batches  []int     `json:"batches"`
+}
+
+// HoldDecision releases or discards the changes held for a list.
+type HoldDecision struct {
+	List   string `json:"list"`
+	Action string `json:"action"`
+}
+
+const (
+	Release = "release"
+	Discard = "discard"
+)
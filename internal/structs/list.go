@@ -13,3 +13,13 @@ type SMCPatch struct {
 	Path  string     `json:"path"`
 	Value string     `json:"value,omitempty"`
 }
+
+type SMCElementResults struct {
+	Results []SMCElementRef `json:"result,omitempty"`
+}
+
+type SMCElementRef struct {
+	Href string `json:"href,omitempty"`
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+}
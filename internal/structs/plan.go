@@ -0,0 +1,28 @@
+package structs
+
+// Plan is the set of SMC operations a request would result in.
+type Plan struct {
+	Lists      []PlannedList `json:"lists"`
+	SnortFiles []string      `json:"snort_files,omitempty"`
+	SnortRules []string      `json:"snort_rules,omitempty"`
+	// SnortRemovals are the requested removals which match a rule of the managed snort files.
+	SnortRemovals []string `json:"snort_removals,omitempty"`
+	// SnortRejected are the rules which are malformed or whose SID is taken.
+	SnortRejected []string `json:"snort_rejected,omitempty"`
+	// Policies are the firewall policies refreshed once the request was applied.
+	Policies []string `json:"refreshed_policies,omitempty"`
+}
+
+// PlannedList is the set of changes a request would make to a single list. Unchanged items are
+// already present, or already absent for removals.
+type PlannedList struct {
+	List      string   `json:"list"`
+	Create    bool     `json:"create,omitempty"`
+	Add       []string `json:"add,omitempty"`
+	Remove    []string `json:"remove,omitempty"`
+	Unchanged []string `json:"unchanged,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+	Rejected  []string `json:"rejected,omitempty"`
+	Held      []string `json:"held_for_approval,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
@@ -1,6 +1,7 @@
 package structs
 
 import (
+	"fmt"
 	"net"
 	"strings"
 )
@@ -18,6 +19,8 @@ type Request struct {
 	Items      []RequestElement `json:"items"`
 	Item       RequestElement   `json:"item"`
 	BatchID    int              `json:"batch_id"`
+	// DryRun requests are planned against the current SMC lists without changing them.
+	DryRun bool `json:"dry_run,omitempty"`
 }
 
 type RequestElement struct {
@@ -42,3 +45,38 @@ func (r *RequestElement) IsValid() bool {
 
 	return isValid
 }
+
+// Normalized returns the value in the form it is stored in SMC lists, so equivalent values are
+// recognised as the same entry. Values which cannot be parsed are returned trimmed.
+func (r RequestElement) Normalized() string {
+	value := strings.TrimSpace(r.Value)
+	switch r.Type {
+	case IP:
+		if ip, network, err := net.ParseCIDR(value); err == nil {
+			ones, _ := network.Mask.Size()
+			return fmt.Sprintf("%s/%d", normalizeIP(ip), ones)
+		}
+		if ip := net.ParseIP(value); ip != nil {
+			return normalizeIP(ip)
+		}
+	case RANGE:
+		bounds := strings.Split(value, "-")
+		if len(bounds) == 2 {
+			first, last := net.ParseIP(strings.TrimSpace(bounds[0])), net.ParseIP(strings.TrimSpace(bounds[1]))
+			if first != nil && last != nil {
+				return normalizeIP(first) + "-" + normalizeIP(last)
+			}
+		}
+	case DOMAIN:
+		return strings.TrimSuffix(strings.ToLower(value), ".")
+	}
+	return value
+}
+
+// normalizeIP formats IPv4 addresses in dotted form, including IPv4-mapped IPv6 addresses.
+func normalizeIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip.String()
+}
@@ -0,0 +1,10 @@
+package structs
+
+import "time"
+
+// SnortBackup is a saved copy of the global snort configuration as it was before an import.
+type SnortBackup struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+}
@@ -2,15 +2,41 @@ package util
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// RulesIncludeFile is the file of the global snort configuration listing the included rule files.
+const RulesIncludeFile = "rules_include.config"
+
+// SmcRulesInclude adds the include line of a rule file to the global snort configuration, unless the
+// file is already included.
 func SmcRulesInclude(exportDirPath string, fileNameToInclude string) error {
 
-	ruleIncludePath := filepath.Join(exportDirPath, "rules_include.config")
+	ruleIncludePath := filepath.Join(exportDirPath, RulesIncludeFile)
+
+	content, err := ioutil.ReadFile(ruleIncludePath)
+
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "error reading rules_include.config")
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "include" && filepath.Base(fields[1]) == fileNameToInclude {
+			return nil
+		}
+	}
+
+	include := fmt.Sprintf("include %s\n", fileNameToInclude)
+	// Start on a new line if the last include was written without one.
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		include = "\n" + include
+	}
 
 	f, err := os.OpenFile(ruleIncludePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 
@@ -20,7 +46,34 @@ func SmcRulesInclude(exportDirPath string, fileNameToInclude string) error {
 
 	defer f.Close()
 
-	if _, err := f.WriteString(fmt.Sprintf("include %s\n", fileNameToInclude)); err != nil {
+	if _, err := f.WriteString(include); err != nil {
+		return errors.Wrap(err, "error writing to rules_include.config")
+	}
+
+	return nil
+}
+
+// SmcRulesExclude removes the include line of a rule file from the global snort configuration.
+func SmcRulesExclude(exportDirPath string, fileNameToExclude string) error {
+
+	ruleIncludePath := filepath.Join(exportDirPath, RulesIncludeFile)
+
+	content, err := ioutil.ReadFile(ruleIncludePath)
+
+	if err != nil {
+		return errors.Wrap(err, "error reading rules_include.config")
+	}
+
+	var kept []string
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "include" && filepath.Base(fields[1]) == fileNameToExclude {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	if err := ioutil.WriteFile(ruleIncludePath, []byte(strings.Join(kept, "\n")), 0644); err != nil {
 		return errors.Wrap(err, "error writing to rules_include.config")
 	}
 
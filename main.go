@@ -3,10 +3,16 @@ package main
 import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"main/internal/batches"
+	"main/internal/cache"
+	"main/internal/channel"
 	"main/internal/config"
+	"main/internal/guardrail"
 	"main/internal/logs"
+	"main/internal/provenance"
 	"main/internal/server"
 	"main/internal/smc"
+	"main/internal/state"
 )
 
 func main() {
@@ -14,6 +20,32 @@ func main() {
 	logs.InitLogrus()
 	// Initialise configuration.
 	config.InitConfig()
+	// Resolve the networks and domains which are never blocklisted.
+	guardrail.Refresh()
+	// Initialise the request queue.
+	channel.Init(viper.GetInt("queue_capacity"))
+	// Initialise the runtime state store.
+	if err := state.Init("./config/state"); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := smc.LoadListIDs(); err != nil {
+		logrus.Error(err)
+	}
+	if err := cache.Load(); err != nil {
+		logrus.Error(err)
+	}
+	if err := provenance.Load(); err != nil {
+		logrus.Error(err)
+	}
+	if err := batches.Load(); err != nil {
+		logrus.Error(err)
+	}
+	if err := smc.LoadBuffer(); err != nil {
+		logrus.Error(err)
+	}
+	if err := smc.LoadWaiting(); err != nil {
+		logrus.Error(err)
+	}
 
 	sesh, _, err := smc.NewSMCSession(
 		viper.GetString("smc_endpoint"),
@@ -24,8 +56,18 @@ func main() {
 		logrus.Error(err)
 	}
 
+	// There is no session while SMC cannot be reached, requests are then held back until it can.
+	loggedIn := sesh != nil && sesh.LoggedIn
+
+	// Check the stored list IDs still refer to lists in SMC.
+	if loggedIn {
+		sesh.VerifyLists()
+	}
+
 	// Register Module.
-	config.RegisterModule(sesh.LoggedIn)
+	config.RegisterModule(loggedIn)
+	// Start the workers before the server, which reports on their queues.
+	smc.StartWorkers()
 	// Handle new requests to server.
 	go smc.HandleRequests(sesh)
 	// Run server to handle incoming requests.